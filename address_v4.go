@@ -3,6 +3,7 @@ package patricia
 import (
 	"encoding/binary"
 	"net"
+	"net/netip"
 )
 
 const _leftmost32Bit = uint32(1 << 31)
@@ -57,3 +58,122 @@ func (i IPv4Address) String() string {
 	}
 	return ipNet.String()
 }
+
+// Masked returns the address with the bits beyond its length cleared
+// - a length over 32 is treated as 32, so no bits are cleared
+func (i IPv4Address) Masked() IPv4Address {
+	return NewIPv4Address(i.Address&_leftMasks32[min(i.Length, 32)], i.Length)
+}
+
+// Contains returns whether this prefix covers the other one - a prefix covers itself
+// - returns false if this prefix is longer than 32 bits
+func (i IPv4Address) Contains(other IPv4Address) bool {
+	return i.Length <= 32 && i.Length <= other.Length && (i.Address^other.Address)&_leftMasks32[i.Length] == 0
+}
+
+// Overlaps returns whether either prefix covers the other
+func (i IPv4Address) Overlaps(other IPv4Address) bool {
+	return i.Contains(other) || other.Contains(i)
+}
+
+// Compare returns -1, 0 or 1 depending on whether this address comes before, is the same as, or comes after
+// the other one, ordering by address and then by length
+// - this is the order trees iterate in, as long as bits beyond the lengths are cleared
+func (i IPv4Address) Compare(other IPv4Address) int {
+	switch {
+	case i.Address < other.Address:
+		return -1
+	case i.Address > other.Address:
+		return 1
+	case i.Length < other.Length:
+		return -1
+	case i.Length > other.Length:
+		return 1
+	}
+	return 0
+}
+
+// First returns the first full-length address covered by this prefix
+// - a length over 32 is treated as 32, returning the address itself
+func (i IPv4Address) First() IPv4Address {
+	return NewIPv4Address(i.Address&_leftMasks32[min(i.Length, 32)], 32)
+}
+
+// Last returns the last full-length address covered by this prefix
+// - a length over 32 is treated as 32, returning the address itself
+func (i IPv4Address) Last() IPv4Address {
+	return NewIPv4Address(i.Address|hostMask32(32-min(i.Length, 32)), 32)
+}
+
+// Next returns the prefix of the same length that comes right after this one
+// - returns false if this is the last prefix of its length, or if it's longer than 32 bits
+func (i IPv4Address) Next() (IPv4Address, bool) {
+	if i.Length == 0 || i.Length > 32 {
+		return IPv4Address{}, false
+	}
+	next := uint64(i.Address&_leftMasks32[i.Length]) + uint64(1)<<(32-i.Length)
+	if next > uint64(^uint32(0)) {
+		return IPv4Address{}, false
+	}
+	return NewIPv4Address(uint32(next), i.Length), true
+}
+
+// Prev returns the prefix of the same length that comes right before this one
+// - returns false if this is the first prefix of its length, or if it's longer than 32 bits
+func (i IPv4Address) Prev() (IPv4Address, bool) {
+	if i.Length == 0 || i.Length > 32 {
+		return IPv4Address{}, false
+	}
+	masked := i.Address & _leftMasks32[i.Length]
+	if masked == 0 {
+		return IPv4Address{}, false
+	}
+	return NewIPv4Address(masked-uint32(1)<<(32-i.Length), i.Length), true
+}
+
+// Supernet returns the prefix one bit shorter than this one, which covers it
+// - returns false for a prefix of length 0, or longer than 32 bits
+func (i IPv4Address) Supernet() (IPv4Address, bool) {
+	if i.Length == 0 || i.Length > 32 {
+		return IPv4Address{}, false
+	}
+	return NewIPv4Address(i.Address&_leftMasks32[i.Length-1], i.Length-1), true
+}
+
+// NetIPPrefix returns the address as a netip.Prefix
+func (i IPv4Address) NetIPPrefix() netip.Prefix {
+	var ip [4]byte
+	binary.BigEndian.PutUint32(ip[:], i.Address)
+	return netip.PrefixFrom(netip.AddrFrom4(ip), int(i.Length))
+}
+
+// MarshalText implements encoding.TextMarshaler, formatting the address as a prefix, like "10.0.0.0/8"
+// - returns ErrInvalidLength if the address is longer than 32 bits
+func (i IPv4Address) MarshalText() ([]byte, error) {
+	if i.Length > 32 {
+		return nil, ErrInvalidLength
+	}
+	return i.NetIPPrefix().AppendTo(nil), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the address as ParseIPv4 does
+func (i *IPv4Address) UnmarshalText(text []byte) error {
+	address, err := ParseIPv4Bytes(text)
+	if err != nil {
+		return err
+	}
+	*i = address
+	return nil
+}
+
+// Validate returns ErrInvalidLength if the address is longer than 32 bits, or ErrHostBitsSet if it has bits
+// set beyond its length
+func (i IPv4Address) Validate() error {
+	if i.Length > 32 {
+		return ErrInvalidLength
+	}
+	if i.Address&^_leftMasks32[i.Length] != 0 {
+		return ErrHostBitsSet
+	}
+	return nil
+}
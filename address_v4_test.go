@@ -1,8 +1,10 @@
 package patricia
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,3 +50,98 @@ func TestNewIPv4AddressFromBytes(t *testing.T) {
 	assert.Equal(t, uint32(0), sut.Address)
 	assert.Equal(t, uint(0), sut.Length)
 }
+
+func TestIPv4AddressArithmetic(t *testing.T) {
+	sut := NewIPv4Address(0x0a010203, 16) // 10.1.2.3/16
+
+	assert.Equal(t, NewIPv4Address(0x0a010000, 16), sut.Masked())
+	assert.Equal(t, NewIPv4Address(0x0a010000, 32), sut.First())
+	assert.Equal(t, NewIPv4Address(0x0a01ffff, 32), sut.Last())
+	assert.Equal(t, netip.MustParsePrefix("10.1.0.0/16"), sut.Masked().NetIPPrefix())
+	assert.Equal(t, netip.MustParsePrefix("0.0.0.0/0"), NewIPv4Address(0, 0).NetIPPrefix())
+
+	assert.True(t, sut.Contains(sut))
+	assert.True(t, sut.Contains(NewIPv4Address(0x0a01ff00, 24)))
+	assert.False(t, sut.Contains(NewIPv4Address(0x0a020000, 24)))
+	assert.False(t, sut.Contains(NewIPv4Address(0x0a000000, 8)))
+	assert.True(t, NewIPv4Address(0, 0).Contains(sut))
+	assert.True(t, sut.Overlaps(NewIPv4Address(0x0a000000, 8)))
+	assert.True(t, sut.Overlaps(NewIPv4Address(0x0a010203, 32)))
+	assert.False(t, sut.Overlaps(NewIPv4Address(0x0b000000, 8)))
+
+	assert.Equal(t, 0, sut.Compare(sut))
+	assert.Equal(t, -1, NewIPv4Address(0x0a000000, 8).Compare(NewIPv4Address(0x0a000000, 16)))
+	assert.Equal(t, 1, NewIPv4Address(0x0a000001, 8).Compare(NewIPv4Address(0x0a000000, 16)))
+
+	next, ok := sut.Next()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv4Address(0x0a020000, 16), next)
+	prev, ok := sut.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv4Address(0x0a000000, 16), prev)
+	_, ok = NewIPv4Address(0xffff0000, 16).Next()
+	assert.False(t, ok)
+	_, ok = NewIPv4Address(0x0000ffff, 16).Prev()
+	assert.False(t, ok)
+	_, ok = NewIPv4Address(0, 0).Next()
+	assert.False(t, ok)
+	next, ok = NewIPv4Address(0x7fffffff, 1).Next()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv4Address(0x80000000, 1), next)
+
+	supernet, ok := sut.Supernet()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv4Address(0x0a000000, 15), supernet)
+	_, ok = NewIPv4Address(0x0a000000, 0).Supernet()
+	assert.False(t, ok)
+
+	// lengths over 32 bits are treated as 32, or fail
+	tooLong := NewIPv4Address(0x0a010203, 33)
+	assert.Equal(t, tooLong, tooLong.Masked())
+	assert.Equal(t, NewIPv4Address(0x0a010203, 32), tooLong.First())
+	assert.Equal(t, NewIPv4Address(0x0a010203, 32), tooLong.Last())
+	assert.False(t, tooLong.Contains(tooLong))
+	assert.True(t, sut.Contains(tooLong))
+	_, ok = tooLong.Next()
+	assert.False(t, ok)
+	_, ok = tooLong.Prev()
+	assert.False(t, ok)
+	_, ok = tooLong.Supernet()
+	assert.False(t, ok)
+}
+
+func TestIPv4AddressValidate(t *testing.T) {
+	assert.NoError(t, NewIPv4Address(0x0a000000, 8).Validate())
+	assert.NoError(t, NewIPv4Address(0, 0).Validate())
+	assert.NoError(t, NewIPv4Address(0xffffffff, 32).Validate())
+	assert.ErrorIs(t, NewIPv4Address(0x0a000001, 8).Validate(), ErrHostBitsSet)
+	assert.ErrorIs(t, NewIPv4Address(0x80000000, 0).Validate(), ErrHostBitsSet)
+	assert.ErrorIs(t, NewIPv4Address(0x0a000000, 33).Validate(), ErrInvalidLength)
+}
+
+func TestIPv4AddressText(t *testing.T) {
+	text, err := NewIPv4Address(0x0a010000, 16).MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.0/16", string(text))
+	_, err = NewIPv4Address(0, 33).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidLength)
+
+	var address IPv4Address
+	assert.NoError(t, address.UnmarshalText([]byte("10.1.2.3/16")))
+	assert.Equal(t, NewIPv4Address(0x0a010000, 16), address)
+	assert.ErrorIs(t, address.UnmarshalText([]byte("2001:db8::/32")), ErrInvalidIPv4Address)
+	assert.Equal(t, NewIPv4Address(0x0a010000, 16), address)
+
+	// as JSON keys and values
+	data, err := json.Marshal(map[IPv4Address][]IPv4Address{
+		NewIPv4Address(0x0a000000, 8): {NewIPv4Address(0x0a000001, 32)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"10.0.0.0/8":["10.0.0.1/32"]}`, string(data))
+
+	var decoded map[IPv4Address][]IPv4Address
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[IPv4Address][]IPv4Address{
+		NewIPv4Address(0x0a000000, 8): {NewIPv4Address(0x0a000001, 32)},
+	}, decoded)
+}
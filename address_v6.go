@@ -2,7 +2,9 @@ package patricia
 
 import (
 	"encoding/binary"
+	"math/bits"
 	"net"
+	"net/netip"
 )
 
 const _leftmost64Bit = uint64(1 << 63)
@@ -30,6 +32,15 @@ func NewIPv6Address(address []byte, length uint) IPv6Address {
 	}
 }
 
+// NewIPv6AddressFromHalves creates an address from the left and right 64 bits of the input IPv6 address
+func NewIPv6AddressFromHalves(left uint64, right uint64, length uint) IPv6Address {
+	return IPv6Address{
+		Left:   left,
+		Right:  right,
+		Length: length,
+	}
+}
+
 // ShiftLeft shifts the bits |bitCount| bits left
 func (ip *IPv6Address) ShiftLeft(bitCount uint) {
 	ip.Left, ip.Right, ip.Length = ShiftLeftIPv6(ip.Left, ip.Right, ip.Length, bitCount)
@@ -82,3 +93,148 @@ func ShiftRightIPv6(left uint64, right uint64, bitCount uint) (uint64, uint64) {
 func (ip *IPv6Address) IsLeftBitSet() bool {
 	return ip.Left >= _leftmost64Bit
 }
+
+// Masked returns the address with the bits beyond its length cleared
+// - a length over 128 is treated as 128, so no bits are cleared
+func (ip IPv6Address) Masked() IPv6Address {
+	left, right, _ := MergePrefixes64(ip.Left, ip.Right, min(ip.Length, 128), 0, 0, 0)
+	return NewIPv6AddressFromHalves(left, right, ip.Length)
+}
+
+// Contains returns whether this prefix covers the other one - a prefix covers itself
+// - returns false if this prefix is longer than 128 bits
+func (ip IPv6Address) Contains(other IPv6Address) bool {
+	if ip.Length > 128 || ip.Length > other.Length {
+		return false
+	}
+	if ip.Length <= 64 {
+		return (ip.Left^other.Left)&_leftMasks64[ip.Length] == 0
+	}
+	return ip.Left == other.Left && (ip.Right^other.Right)&_leftMasks64[ip.Length-64] == 0
+}
+
+// Overlaps returns whether either prefix covers the other
+func (ip IPv6Address) Overlaps(other IPv6Address) bool {
+	return ip.Contains(other) || other.Contains(ip)
+}
+
+// Compare returns -1, 0 or 1 depending on whether this address comes before, is the same as, or comes after
+// the other one, ordering by address and then by length
+// - this is the order trees iterate in, as long as bits beyond the lengths are cleared
+func (ip IPv6Address) Compare(other IPv6Address) int {
+	if ret := compare128(ip.Left, ip.Right, other.Left, other.Right); ret != 0 {
+		return ret
+	}
+	switch {
+	case ip.Length < other.Length:
+		return -1
+	case ip.Length > other.Length:
+		return 1
+	}
+	return 0
+}
+
+// First returns the first full-length address covered by this prefix
+// - a length over 128 is treated as 128, returning the address itself
+func (ip IPv6Address) First() IPv6Address {
+	masked := ip.Masked()
+	masked.Length = 128
+	return masked
+}
+
+// Last returns the last full-length address covered by this prefix
+// - a length over 128 is treated as 128, returning the address itself
+func (ip IPv6Address) Last() IPv6Address {
+	maskLeft, maskRight := hostMask128(128 - min(ip.Length, 128))
+	return NewIPv6AddressFromHalves(ip.Left|maskLeft, ip.Right|maskRight, 128)
+}
+
+// Next returns the prefix of the same length that comes right after this one
+// - returns false if this is the last prefix of its length, or if it's longer than 128 bits
+func (ip IPv6Address) Next() (IPv6Address, bool) {
+	if ip.Length == 0 || ip.Length > 128 {
+		return IPv6Address{}, false
+	}
+	masked := ip.Masked()
+	stepLeft, stepRight := prefixSize128(ip.Length)
+	right, carry := bits.Add64(masked.Right, stepRight, 0)
+	left, overflow := bits.Add64(masked.Left, stepLeft, carry)
+	if overflow != 0 {
+		return IPv6Address{}, false
+	}
+	return NewIPv6AddressFromHalves(left, right, ip.Length), true
+}
+
+// Prev returns the prefix of the same length that comes right before this one
+// - returns false if this is the first prefix of its length, or if it's longer than 128 bits
+func (ip IPv6Address) Prev() (IPv6Address, bool) {
+	if ip.Length == 0 || ip.Length > 128 {
+		return IPv6Address{}, false
+	}
+	masked := ip.Masked()
+	stepLeft, stepRight := prefixSize128(ip.Length)
+	right, borrow := bits.Sub64(masked.Right, stepRight, 0)
+	left, underflow := bits.Sub64(masked.Left, stepLeft, borrow)
+	if underflow != 0 {
+		return IPv6Address{}, false
+	}
+	return NewIPv6AddressFromHalves(left, right, ip.Length), true
+}
+
+// Supernet returns the prefix one bit shorter than this one, which covers it
+// - returns false for a prefix of length 0, or longer than 128 bits
+func (ip IPv6Address) Supernet() (IPv6Address, bool) {
+	if ip.Length == 0 || ip.Length > 128 {
+		return IPv6Address{}, false
+	}
+	left, right, length := MergePrefixes64(ip.Left, ip.Right, ip.Length-1, 0, 0, 0)
+	return NewIPv6AddressFromHalves(left, right, length), true
+}
+
+// NetIPPrefix returns the address as a netip.Prefix
+func (ip IPv6Address) NetIPPrefix() netip.Prefix {
+	var addr [16]byte
+	binary.BigEndian.PutUint64(addr[:8], ip.Left)
+	binary.BigEndian.PutUint64(addr[8:], ip.Right)
+	return netip.PrefixFrom(netip.AddrFrom16(addr), int(ip.Length))
+}
+
+// MarshalText implements encoding.TextMarshaler, formatting the address as a prefix, like "2001:db8::/32"
+// - IPv4-mapped addresses are formatted like "::ffff:10.0.0.0/104"
+// - returns ErrInvalidLength if the address is longer than 128 bits
+func (ip IPv6Address) MarshalText() ([]byte, error) {
+	if ip.Length > 128 {
+		return nil, ErrInvalidLength
+	}
+	return ip.NetIPPrefix().AppendTo(nil), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the address as ParseIPv6 does
+func (ip *IPv6Address) UnmarshalText(text []byte) error {
+	address, err := ParseIPv6Bytes(text)
+	if err != nil {
+		return err
+	}
+	*ip = address
+	return nil
+}
+
+// Validate returns ErrInvalidLength if the address is longer than 128 bits, or ErrHostBitsSet if it has bits
+// set beyond its length
+func (ip IPv6Address) Validate() error {
+	if ip.Length > 128 {
+		return ErrInvalidLength
+	}
+	if ip.Masked() != ip {
+		return ErrHostBitsSet
+	}
+	return nil
+}
+
+// prefixSize128 returns the number of addresses in a prefix of the input length, which must be at least 1, as two uint64s
+func prefixSize128(length uint) (uint64, uint64) {
+	if length <= 64 {
+		return uint64(1) << (64 - length), 0
+	}
+	return 0, uint64(1) << (128 - length)
+}
@@ -1,7 +1,9 @@
 package patricia
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -87,3 +89,99 @@ func TestShiftRightIPv6(t *testing.T) {
 	assert.Equal(t, uint64(0x0), newLeft)
 	assert.Equal(t, uint64(0x81018202830), newRight)
 }
+
+func TestIPv6AddressArithmetic(t *testing.T) {
+	sut := NewIPv6AddressFromHalves(0x20010db800010002, 0x1, 48) // 2001:db8:1:2::1/48
+	assert.Equal(t, IPv6Address{Left: 0x20010db800010002, Right: 0x1, Length: 48}, sut)
+
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db800010000, 0, 48), sut.Masked())
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db800010000, 0, 128), sut.First())
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db80001ffff, 0xffffffffffffffff, 128), sut.Last())
+	assert.Equal(t, netip.MustParsePrefix("2001:db8:1::/48"), sut.Masked().NetIPPrefix())
+
+	assert.True(t, sut.Contains(NewIPv6AddressFromHalves(0x20010db80001ffff, 0, 64)))
+	assert.False(t, sut.Contains(NewIPv6AddressFromHalves(0x20010db800020000, 0, 64)))
+	long := NewIPv6AddressFromHalves(0x20010db800010002, 0xff00000000000000, 72)
+	assert.True(t, long.Contains(NewIPv6AddressFromHalves(0x20010db800010002, 0xff00000000000001, 128)))
+	assert.False(t, long.Contains(NewIPv6AddressFromHalves(0x20010db800010002, 0xfe00000000000001, 128)))
+	assert.True(t, long.Overlaps(sut))
+	assert.False(t, long.Overlaps(NewIPv6AddressFromHalves(0x20010db800010003, 0, 64)))
+
+	assert.Equal(t, -1, sut.Masked().Compare(long))
+	assert.Equal(t, 1, long.Compare(sut.Masked()))
+	assert.Equal(t, -1, long.Masked().Compare(NewIPv6AddressFromHalves(0x20010db800010002, 0xff00000000000000, 73)))
+	assert.Equal(t, 0, long.Compare(long))
+
+	// carrying across the halves
+	next, ok := NewIPv6AddressFromHalves(0x1, 0xffffffffffffff00, 120).Next()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv6AddressFromHalves(0x2, 0, 120), next)
+	prev, ok := next.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv6AddressFromHalves(0x1, 0xffffffffffffff00, 120), prev)
+	next, ok = sut.Next()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db800020000, 0, 48), next)
+	_, ok = NewIPv6AddressFromHalves(0xffffffffffffffff, 0xffffffffffffffff, 128).Next()
+	assert.False(t, ok)
+	_, ok = NewIPv6AddressFromHalves(0, 0, 64).Prev()
+	assert.False(t, ok)
+
+	supernet, ok := NewIPv6AddressFromHalves(0x1, 0x8000000000000000, 65).Supernet()
+	assert.True(t, ok)
+	assert.Equal(t, NewIPv6AddressFromHalves(0x1, 0, 64), supernet)
+	_, ok = NewIPv6AddressFromHalves(0, 0, 0).Supernet()
+	assert.False(t, ok)
+
+	// lengths over 128 bits are treated as 128, or fail
+	tooLong := NewIPv6AddressFromHalves(0x20010db800010002, 0x1, 129)
+	assert.Equal(t, tooLong, tooLong.Masked())
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db800010002, 0x1, 128), tooLong.First())
+	assert.Equal(t, NewIPv6AddressFromHalves(0x20010db800010002, 0x1, 128), tooLong.Last())
+	assert.False(t, tooLong.Contains(tooLong))
+	assert.True(t, sut.Contains(tooLong))
+	_, ok = tooLong.Next()
+	assert.False(t, ok)
+	_, ok = tooLong.Prev()
+	assert.False(t, ok)
+	_, ok = tooLong.Supernet()
+	assert.False(t, ok)
+}
+
+func TestIPv6AddressValidate(t *testing.T) {
+	assert.NoError(t, NewIPv6AddressFromHalves(0x20010db800000000, 0, 32).Validate())
+	assert.NoError(t, NewIPv6AddressFromHalves(0x20010db800000000, 0x8000000000000000, 65).Validate())
+	assert.NoError(t, NewIPv6AddressFromHalves(0xffffffffffffffff, 0xffffffffffffffff, 128).Validate())
+	assert.ErrorIs(t, NewIPv6AddressFromHalves(0x20010db800000000, 1, 64).Validate(), ErrHostBitsSet)
+	assert.ErrorIs(t, NewIPv6AddressFromHalves(0x20010db800000000, 0, 16).Validate(), ErrHostBitsSet)
+	assert.ErrorIs(t, NewIPv6AddressFromHalves(0, 0, 129).Validate(), ErrInvalidLength)
+}
+
+func TestIPv6AddressText(t *testing.T) {
+	text, err := NewIPv6AddressFromHalves(0x20010db800000000, 0, 32).MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::/32", string(text))
+	text, err = NewIPv6AddressFromHalves(0, 0x0000ffff0a000000, 104).MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "::ffff:10.0.0.0/104", string(text))
+	_, err = NewIPv6AddressFromHalves(0, 0, 129).MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidLength)
+
+	var address IPv6Address
+	assert.NoError(t, address.UnmarshalText([]byte("::ffff:10.0.0.0/104")))
+	assert.Equal(t, NewIPv6AddressFromHalves(0, 0x0000ffff0a000000, 104), address)
+	assert.ErrorIs(t, address.UnmarshalText([]byte("10.0.0.0/8")), ErrInvalidIPv6Address)
+
+	// as JSON keys and values
+	data, err := json.Marshal(map[IPv6Address]IPv6Address{
+		NewIPv6AddressFromHalves(0x20010db800000000, 0, 32): NewIPv6AddressFromHalves(0x20010db800000000, 1, 128),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"2001:db8::/32":"2001:db8::1/128"}`, string(data))
+
+	var decoded map[IPv6Address]IPv6Address
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[IPv6Address]IPv6Address{
+		NewIPv6AddressFromHalves(0x20010db800000000, 0, 32): NewIPv6AddressFromHalves(0x20010db800000000, 1, 128),
+	}, decoded)
+}
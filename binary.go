@@ -0,0 +1,18 @@
+package patricia
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidBinary is returned when unmarshaling data that isn't a binary encoded tree, or is truncated
+	ErrInvalidBinary = errors.New("invalid binary tree data")
+	// ErrUnsupportedVersion is returned when unmarshaling a binary encoded tree written by an incompatible version
+	ErrUnsupportedVersion = errors.New("unsupported binary tree version")
+	// ErrChecksumMismatch is returned when unmarshaling a binary encoded tree whose checksum doesn't match its contents
+	ErrChecksumMismatch = errors.New("binary tree checksum mismatch")
+	// ErrIncompatibleBinary is returned when unmarshaling a binary encoded tree of a different address family or tag type
+	ErrIncompatibleBinary = errors.New("binary tree data doesn't match the tree type")
+	// ErrUnsupportedTagType is returned when marshaling a tree whose tags aren't bools, strings, or fixed-size numbers
+	ErrUnsupportedTagType = errors.New("unsupported tag type")
+)
@@ -3,6 +3,7 @@
 package bool_tree
 
 import (
+	"encoding/binary"
 	"math/bits"
 
 	"github.com/kentik/patricia"
@@ -10,6 +11,12 @@ import (
 
 const _leftmost64Bit = uint64(1 << 63)
 
+// treeNodeV6BinarySize is the size of a node in the binary encoding: Left, Right, prefixLeft, prefixRight, prefixLength, TagCount
+const treeNodeV6BinarySize = 4 + 4 + 8 + 8 + 1 + 4
+
+// treeNodeV6FrozenSize is the size of a node in the frozen layout: the binary encoding, then the offset of its tags
+const treeNodeV6FrozenSize = treeNodeV6BinarySize + 8
+
 type treeNodeV6 struct {
 	Left         uint // left node index: 0 for not set
 	Right        uint // right node index: 0 for not set
@@ -49,3 +56,24 @@ func (n *treeNodeV6) IsLeftBitSet() bool {
 func (n *treeNodeV6) MergeFromNodes(left *treeNodeV6, right *treeNodeV6) {
 	n.prefixLeft, n.prefixRight, n.prefixLength = patricia.MergePrefixes64(left.prefixLeft, left.prefixRight, left.prefixLength, right.prefixLeft, right.prefixRight, right.prefixLength)
 }
+
+// appendBinary appends the binary encoding of the node to buf
+func (n *treeNodeV6) appendBinary(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n.Left))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n.Right))
+	buf = binary.BigEndian.AppendUint64(buf, n.prefixLeft)
+	buf = binary.BigEndian.AppendUint64(buf, n.prefixRight)
+	buf = append(buf, byte(n.prefixLength))
+	return binary.BigEndian.AppendUint32(buf, uint32(n.TagCount))
+}
+
+// readBinary reads the node from the first treeNodeV6BinarySize bytes of buf, returning the rest
+func (n *treeNodeV6) readBinary(buf []byte) []byte {
+	n.Left = uint(binary.BigEndian.Uint32(buf))
+	n.Right = uint(binary.BigEndian.Uint32(buf[4:]))
+	n.prefixLeft = binary.BigEndian.Uint64(buf[8:])
+	n.prefixRight = binary.BigEndian.Uint64(buf[16:])
+	n.prefixLength = uint(buf[24])
+	n.TagCount = int(binary.BigEndian.Uint32(buf[25:]))
+	return buf[treeNodeV6BinarySize:]
+}
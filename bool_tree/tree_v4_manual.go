@@ -3,7 +3,9 @@
 package bool_tree
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net/netip"
 
 	"github.com/kentik/patricia"
 )
@@ -24,8 +26,92 @@ func (t *TreeV4) newNode(address patricia.IPv4Address, prefixLength uint) uint {
 	return uint(len(t.nodes) - 1)
 }
 
-// Address returns the current IP address for the iterator.
-func (iter *TreeIteratorV4) Address() patricia.IPv4Address {
+// return the input address truncated to the input prefix length, with the remaining bits cleared
+func (t *TreeV4) maskedAddress(address patricia.IPv4Address, prefixLength uint) patricia.IPv4Address {
+	prefix, _ := patricia.MergePrefixes32(address.Address, prefixLength, 0, 0)
+	return patricia.NewIPv4Address(prefix, prefixLength)
+}
+
+// return the input prefix as an IPv4 address
+// - host bits past the prefix length are cleared, so 10.1.2.3/8 is 10.0.0.0/8
+// - returns an error if it's not a valid IPv4 prefix
+func (t *TreeV4) addressFromPrefix(prefix netip.Prefix) (patricia.IPv4Address, error) {
+	if !prefix.IsValid() {
+		return patricia.IPv4Address{}, patricia.ErrInvalidAddress
+	}
+	address, err := t.addressFromAddr(prefix.Masked().Addr())
+	address.Length = uint(prefix.Bits())
+	return address, err
+}
+
+// return the input address as a full-length IPv4 address
+// - returns an error if it's not a valid IPv4 address
+func (t *TreeV4) addressFromAddr(addr netip.Addr) (patricia.IPv4Address, error) {
+	if !addr.IsValid() {
+		return patricia.IPv4Address{}, patricia.ErrInvalidAddress
+	}
+	if !addr.Is4() {
+		return patricia.IPv4Address{}, patricia.ErrAddressFamily
+	}
+	ip := addr.As4()
+	return patricia.NewIPv4Address(binary.BigEndian.Uint32(ip[:]), 32), nil
+}
+
+// parse the input text as an IPv4 prefix, with an optional length, as written in TSV lines
+// - returns patricia.ErrAddressFamily for an IPv6 prefix
+func (t *TreeV4) parseAddress(text string) (patricia.IPv4Address, error) {
+	address, _, isV4, err := patricia.ParsePrefix(text)
+	if err == nil && !isV4 {
+		err = patricia.ErrAddressFamily
+	}
+	return address, err
+}
+
+// return the number of bits in an address
+func (t *TreeV4) addressBits() uint {
+	return 32
+}
+
+// return the number of bits in an address
+func (t *FrozenTreeV4) addressBits() uint {
+	return 32
+}
+
+// return the minimal set of prefixes covering start to end, inclusive
+func (t *TreeV4) rangeToPrefixes(start patricia.IPv4Address, end patricia.IPv4Address) []patricia.IPv4Address {
+	return patricia.RangeToPrefixesV4(start, end)
+}
+
+// return the input address extended by one bit, which is set if right is true
+func (t *TreeV4) childAddress(address patricia.IPv4Address, right bool) patricia.IPv4Address {
+	var bit uint32
+	if right {
+		bit = uint32(1 << 31)
+	}
+	prefix, prefixLength := patricia.MergePrefixes32(address.Address, address.Length, bit, 1)
+	return patricia.NewIPv4Address(prefix, prefixLength)
+}
+
+// pushNode moves the iterator down to the input child of its current node
+func (iter *TreeIteratorV4) pushNode(childIndex uint) {
+	child := &iter.t.nodes[childIndex]
+	iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
+	iter.nodeIndex = childIndex
+	iter.address.Address, iter.address.Length = patricia.MergePrefixes32(iter.address.Address, iter.address.Length,
+		child.prefix, child.prefixLength)
+}
+
+// popNode moves the iterator up to the parent of its current node
+func (iter *TreeIteratorV4) popNode() {
+	nodeHistoryLen := len(iter.nodeHistory)
+	iter.address.Length -= iter.t.nodes[iter.nodeIndex].prefixLength
+	iter.address.Address, _ = patricia.MergePrefixes32(iter.address.Address, iter.address.Length, 0, 0)
+	iter.nodeIndex = iter.nodeHistory[nodeHistoryLen-1]
+	iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
+}
+
+// updateAddress rebuilds the current IP address for the iterator from its node history
+func (iter *TreeIteratorV4) updateAddress() {
 	var prefix uint32
 	var prefixLength uint
 	for _, i := range iter.nodeHistory {
@@ -34,7 +120,22 @@ func (iter *TreeIteratorV4) Address() patricia.IPv4Address {
 	}
 	prefix, prefixLength = patricia.MergePrefixes32(prefix, prefixLength,
 		iter.t.nodes[iter.nodeIndex].prefix, iter.t.nodes[iter.nodeIndex].prefixLength)
-	return patricia.NewIPv4Address(prefix, prefixLength)
+	iter.address = patricia.NewIPv4Address(prefix, prefixLength)
+}
+
+// Address returns the current IP address for the iterator.
+func (iter *TreeIteratorV4) Address() patricia.IPv4Address {
+	return iter.address
+}
+
+// Prefix returns the current IP address for the iterator, as a netip.Prefix.
+func (iter *TreeIteratorV4) Prefix() netip.Prefix {
+	return iter.address.NetIPPrefix()
+}
+
+// beforeUpperBound returns whether the current IP address for the iterator is before its upper bound
+func (iter *TreeIteratorV4) beforeUpperBound() bool {
+	return iter.address.Compare(iter.upperBound) < 0
 }
 
 //nolint
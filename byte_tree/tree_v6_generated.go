@@ -3,7 +3,14 @@
 package byte_tree
 
 import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"net/netip"
 
 	"github.com/kentik/patricia"
 )
@@ -157,6 +164,49 @@ func (t *TreeV6) Add(address patricia.IPv6Address, tag byte, matchFunc MatchesFu
 	return t.add(address, tag, matchFunc, nil)
 }
 
+// SetE is a strict version of Set, which validates the address first
+// - returns patricia.ErrInvalidLength if the address is too long, or patricia.ErrHostBitsSet if it has bits set beyond its length
+func (t *TreeV6) SetE(address patricia.IPv6Address, tag byte) (bool, int, error) {
+	if err := address.Validate(); err != nil {
+		return false, 0, err
+	}
+	countIncreased, count := t.Set(address, tag)
+	return countIncreased, count, nil
+}
+
+// AddE is a strict version of Add, which validates the address first
+// - returns patricia.ErrInvalidLength if the address is too long, or patricia.ErrHostBitsSet if it has bits set beyond its length
+func (t *TreeV6) AddE(address patricia.IPv6Address, tag byte, matchFunc MatchesFunc) (bool, int, error) {
+	if err := address.Validate(); err != nil {
+		return false, 0, err
+	}
+	countIncreased, count := t.Add(address, tag, matchFunc)
+	return countIncreased, count, nil
+}
+
+// SetRange sets the single value for each prefix in the minimal set covering start to end, inclusive - overwrites what's there
+// - the lengths of start and end are ignored
+// - returns the number of prefixes the range was split into
+func (t *TreeV6) SetRange(start patricia.IPv6Address, end patricia.IPv6Address, tag byte) int {
+	prefixes := t.rangeToPrefixes(start, end)
+	for _, prefix := range prefixes {
+		t.Set(prefix, tag)
+	}
+	return len(prefixes)
+}
+
+// AddRange adds a tag to each prefix in the minimal set covering start to end, inclusive
+// - the lengths of start and end are ignored
+// - if matchFunc is non-nil, it will be used to ensure uniqueness at each node
+// - returns the number of prefixes the range was split into
+func (t *TreeV6) AddRange(start patricia.IPv6Address, end patricia.IPv6Address, tag byte, matchFunc MatchesFunc) int {
+	prefixes := t.rangeToPrefixes(start, end)
+	for _, prefix := range prefixes {
+		t.Add(prefix, tag, matchFunc)
+	}
+	return len(prefixes)
+}
+
 // SetOrUpdate the single value for a node - overwrites what's there using updateFunc if present
 // - returns whether the tag count at this address was increased, and how many tags at this address
 func (t *TreeV6) SetOrUpdate(address patricia.IPv6Address, tag byte, updateFunc UpdatesFunc) (bool, int) {
@@ -172,6 +222,26 @@ func (t *TreeV6) AddOrUpdate(address patricia.IPv6Address, tag byte, matchFunc M
 	return t.add(address, tag, matchFunc, updateFunc)
 }
 
+// SetOrUpdateE is a strict version of SetOrUpdate, which validates the address first
+// - returns patricia.ErrInvalidLength if the address is too long, or patricia.ErrHostBitsSet if it has bits set beyond its length
+func (t *TreeV6) SetOrUpdateE(address patricia.IPv6Address, tag byte, updateFunc UpdatesFunc) (bool, int, error) {
+	if err := address.Validate(); err != nil {
+		return false, 0, err
+	}
+	countIncreased, count := t.SetOrUpdate(address, tag, updateFunc)
+	return countIncreased, count, nil
+}
+
+// AddOrUpdateE is a strict version of AddOrUpdate, which validates the address first
+// - returns patricia.ErrInvalidLength if the address is too long, or patricia.ErrHostBitsSet if it has bits set beyond its length
+func (t *TreeV6) AddOrUpdateE(address patricia.IPv6Address, tag byte, matchFunc MatchesFunc, updateFunc UpdatesFunc) (bool, int, error) {
+	if err := address.Validate(); err != nil {
+		return false, 0, err
+	}
+	countIncreased, count := t.AddOrUpdate(address, tag, matchFunc, updateFunc)
+	return countIncreased, count, nil
+}
+
 // add a tag to the tree, optionally updating the existing value
 // - overwrites the first value in the list if updateFunc function is provided (tag is ignored in this case)
 // - returns whether the tag count was increased, and the number of tags at this address
@@ -338,6 +408,15 @@ func (t *TreeV6) Delete(address patricia.IPv6Address, matchFunc MatchesFunc, mat
 	return t.DeleteWithBuffer(nil, address, matchFunc, matchVal)
 }
 
+// DeleteE is a strict version of Delete, which validates the address first
+// - returns patricia.ErrInvalidLength if the address is too long, or patricia.ErrHostBitsSet if it has bits set beyond its length
+func (t *TreeV6) DeleteE(address patricia.IPv6Address, matchFunc MatchesFunc, matchVal byte) (int, error) {
+	if err := address.Validate(); err != nil {
+		return 0, err
+	}
+	return t.Delete(address, matchFunc, matchVal), nil
+}
+
 // DeleteWithBuffer a tag from the tree if it matches matchVal, as determined by matchFunc. Returns how many tags are removed
 // - uses input slice to reduce allocations
 func (t *TreeV6) DeleteWithBuffer(buf []byte, address patricia.IPv6Address, matchFunc MatchesFunc, matchVal byte) int {
@@ -568,12 +647,294 @@ func (t *TreeV6) FindTagsWithFilterAppend(ret []byte, address patricia.IPv6Addre
 	}
 }
 
+// GetExact finds the tags stored at exactly the input address, ignoring any covering prefixes
+// - returns whether any tags were found
+// - use GetExactAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) GetExact(address patricia.IPv6Address) (bool, []byte) {
+	ret := make([]byte, 0)
+	return t.GetExactAppend(ret, address)
+}
+
+// GetExactAppend finds the tags stored at exactly the input address, ignoring any covering prefixes
+// - returns whether any tags were found
+// - results are appended to the input slice
+func (t *TreeV6) GetExactAppend(ret []byte, address patricia.IPv6Address) (bool, []byte) {
+	nodeIndex := t.exactNodeIndex(address)
+	if nodeIndex == 0 || t.nodes[nodeIndex].TagCount == 0 {
+		return false, ret
+	}
+	return true, t.tagsForNode(ret, nodeIndex, nil)
+}
+
+// exactNodeIndex returns the index of the node whose prefix is exactly the input address, or 0 if there's none
+func (t *TreeV6) exactNodeIndex(address patricia.IPv6Address) uint {
+	if address.Length == 0 {
+		return 1
+	}
+
+	root := &t.nodes[1]
+	var nodeIndex uint
+	if !address.IsLeftBitSet() {
+		nodeIndex = root.Left
+	} else {
+		nodeIndex = root.Right
+	}
+
+	// traverse the tree
+	for {
+		if nodeIndex == 0 {
+			return 0
+		}
+		node := &t.nodes[nodeIndex]
+
+		matchCount := node.MatchCount(address)
+		if matchCount < node.prefixLength {
+			// didn't match the entire node - we're done
+			return 0
+		}
+
+		if matchCount == address.Length {
+			// exact match - we're done
+			return nodeIndex
+		}
+
+		// there's still more address - keep traversing
+		address.ShiftLeft(matchCount)
+		if !address.IsLeftBitSet() {
+			nodeIndex = node.Left
+		} else {
+			nodeIndex = node.Right
+		}
+	}
+}
+
+// MatchV6 is a tag found in the tree, along with the prefix it's stored at
+type MatchV6 struct {
+	Prefix patricia.IPv6Address
+	Tag    byte
+}
+
+// FindMatches finds all matching tags for given address, along with the prefix each was stored at
+// - results are in order from the least to the most specific prefix
+// - use FindMatchesAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) FindMatches(address patricia.IPv6Address) []MatchV6 {
+	ret := make([]MatchV6, 0)
+	return t.FindMatchesAppend(ret, address)
+}
+
+// FindMatchesAppend finds all matching tags for given address, along with the prefix each was stored at
+// - results are in order from the least to the most specific prefix
+// - results are appended to the input slice
+func (t *TreeV6) FindMatchesAppend(ret []MatchV6, address patricia.IPv6Address) []MatchV6 {
+	var matchLength uint
+	root := &t.nodes[1]
+	original := address
+
+	if root.TagCount > 0 {
+		ret = t.matchesForNode(ret, 1, t.maskedAddress(original, 0))
+	}
+
+	if address.Length == 0 {
+		// caller just looking for root tags
+		return ret
+	}
+
+	var nodeIndex uint
+	if !address.IsLeftBitSet() {
+		nodeIndex = root.Left
+	} else {
+		nodeIndex = root.Right
+	}
+
+	// traverse the tree
+	for {
+		if nodeIndex == 0 {
+			return ret
+		}
+		node := &t.nodes[nodeIndex]
+
+		matchCount := node.MatchCount(address)
+		if matchCount < node.prefixLength {
+			// didn't match the entire node - we're done
+			return ret
+		}
+		matchLength += matchCount
+
+		// matched the full node - get its tags, then chop off the bits we've already matched and continue
+		if node.TagCount > 0 {
+			ret = t.matchesForNode(ret, nodeIndex, t.maskedAddress(original, matchLength))
+		}
+
+		if matchCount == address.Length {
+			// exact match - we're done
+			return ret
+		}
+
+		// there's still more address - keep traversing
+		address.ShiftLeft(matchCount)
+		if !address.IsLeftBitSet() {
+			nodeIndex = node.Left
+		} else {
+			nodeIndex = node.Right
+		}
+	}
+}
+
+// return the tags at the input node index, paired with the input prefix - appending to the input slice
+func (t *TreeV6) matchesForNode(ret []MatchV6, nodeIndex uint, prefix patricia.IPv6Address) []MatchV6 {
+	tagCount := t.nodes[nodeIndex].TagCount
+	key := uint64(nodeIndex) << 32
+	for i := 0; i < tagCount; i++ {
+		ret = append(ret, MatchV6{Prefix: prefix, Tag: t.tags[key+uint64(i)]})
+	}
+	return ret
+}
+
+// OverlapV6 is a prefix in the tree that overlaps a queried address, along with its tags
+type OverlapV6 struct {
+	Prefix patricia.IPv6Address
+	Tags   []byte
+	Type   OverlapType
+}
+
+// FindOverlapping finds all prefixes with tags that overlap the input address: the prefixes covering it,
+// the address itself, and all of the more-specific prefixes it covers
+// - results are in tree iteration order, so covering prefixes come first, from the least to the most specific
+// - use FindOverlappingAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) FindOverlapping(address patricia.IPv6Address) []OverlapV6 {
+	ret := make([]OverlapV6, 0)
+	return t.FindOverlappingAppend(ret, address)
+}
+
+// FindOverlappingAppend finds all prefixes with tags that overlap the input address
+// - results are in tree iteration order, so covering prefixes come first, from the least to the most specific
+// - results are appended to the input slice
+func (t *TreeV6) FindOverlappingAppend(ret []OverlapV6, address patricia.IPv6Address) []OverlapV6 {
+	original := t.maskedAddress(address, address.Length)
+
+	if address.Length > 0 {
+		// find the covering prefixes - the address itself is found along with the covered prefixes below
+		root := &t.nodes[1]
+		if root.TagCount > 0 {
+			ret = append(ret, OverlapV6{Prefix: t.maskedAddress(original, 0), Tags: t.tagsForNode(nil, 1, nil), Type: OverlapCovers})
+		}
+
+		var matchLength uint
+		var nodeIndex uint
+		if !address.IsLeftBitSet() {
+			nodeIndex = root.Left
+		} else {
+			nodeIndex = root.Right
+		}
+
+		for nodeIndex != 0 {
+			node := &t.nodes[nodeIndex]
+			matchCount := node.MatchCount(address)
+			if matchCount < node.prefixLength || matchCount == address.Length {
+				// the node isn't a strict supernet of the address
+				break
+			}
+			matchLength += matchCount
+
+			if node.TagCount > 0 {
+				ret = append(ret, OverlapV6{Prefix: t.maskedAddress(original, matchLength), Tags: t.tagsForNode(nil, nodeIndex, nil), Type: OverlapCovers})
+			}
+
+			address.ShiftLeft(matchCount)
+			if !address.IsLeftBitSet() {
+				nodeIndex = node.Left
+			} else {
+				nodeIndex = node.Right
+			}
+		}
+	}
+
+	treeIter := t.IterateSubtree(original)
+	for treeIter.Next() {
+		overlapType := OverlapCovered
+		if treeIter.Address().Length == original.Length {
+			overlapType = OverlapExact
+		}
+		ret = append(ret, OverlapV6{Prefix: treeIter.Address(), Tags: treeIter.Tags(), Type: overlapType})
+	}
+	return ret
+}
+
+// NeighborV6 is a tagged prefix next to a queried address, along with its tags
+type NeighborV6 struct {
+	Found  bool
+	Prefix patricia.IPv6Address
+	Tags   []byte
+}
+
+// FindNeighbors finds the closest tagged prefixes before and after the input address, in the order described in
+// IterateFrom, that don't overlap it
+// - useful for finding the nearest known prefixes when an address has no match
+// - each neighbor's Found field is false if there's no such prefix
+func (t *TreeV6) FindNeighbors(address patricia.IPv6Address) (NeighborV6, NeighborV6) {
+	var predecessor, successor NeighborV6
+	original := t.maskedAddress(address, address.Length)
+	predecessorIter := &TreeIteratorV6{
+		t:       t,
+		subtree: true,
+		reverse: true,
+	}
+	successorIter := &TreeIteratorV6{
+		t:       t,
+		subtree: true,
+	}
+
+	// each bit of the address has a sibling subtree that's entirely before or after it - closest ones first
+	for length := original.Length; length > 0 && !(predecessor.Found && successor.Found); length-- {
+		parent := t.maskedAddress(original, length-1)
+		if t.childAddress(parent, true).Compare(t.maskedAddress(original, length)) == 0 {
+			if !predecessor.Found {
+				predecessor = t.firstNeighbor(predecessorIter, t.childAddress(parent, false))
+			}
+		} else if !successor.Found {
+			successor = t.firstNeighbor(successorIter, t.childAddress(parent, true))
+		}
+	}
+	return predecessor, successor
+}
+
+// firstNeighbor returns the first prefix the input iterator finds in the subtree of the input address
+func (t *TreeV6) firstNeighbor(treeIter *TreeIteratorV6, address patricia.IPv6Address) NeighborV6 {
+	treeIter.start = address
+	treeIter.Reset()
+	if !treeIter.Next() {
+		return NeighborV6{}
+	}
+	return NeighborV6{
+		Found:  true,
+		Prefix: treeIter.Address(),
+		Tags:   treeIter.Tags(),
+	}
+}
+
 // FindDeepestTag finds a tag at the deepest level in the tree, representing the closest match.
 // - if that target node has multiple tags, the first in the list is returned
 func (t *TreeV6) FindDeepestTag(address patricia.IPv6Address) (bool, byte) {
+	found, _, ret := t.findDeepestTag(address)
+	return found, ret
+}
+
+// FindDeepestTagWithPrefix finds a tag at the deepest level in the tree, representing the closest match,
+// along with the prefix of the node it was found at
+// - if that target node has multiple tags, the first in the list is returned
+// - the returned prefix is only meaningful if a tag was found
+func (t *TreeV6) FindDeepestTagWithPrefix(address patricia.IPv6Address) (bool, patricia.IPv6Address, byte) {
+	found, matchLength, ret := t.findDeepestTag(address)
+	return found, t.maskedAddress(address, matchLength), ret
+}
+
+// findDeepestTag finds the first tag at the deepest level in the tree, and how many bits of the address the node matched
+func (t *TreeV6) findDeepestTag(address patricia.IPv6Address) (bool, uint, byte) {
 	root := &t.nodes[1]
 	var found bool
 	var ret byte
+	var retLength uint
+	var matchLength uint
 
 	if root.TagCount > 0 {
 		ret = t.firstTagForNode(1)
@@ -582,7 +943,7 @@ func (t *TreeV6) FindDeepestTag(address patricia.IPv6Address) (bool, byte) {
 
 	if address.Length == 0 {
 		// caller just looking for root tags
-		return found, ret
+		return found, retLength, ret
 	}
 
 	var nodeIndex uint
@@ -595,25 +956,27 @@ func (t *TreeV6) FindDeepestTag(address patricia.IPv6Address) (bool, byte) {
 	// traverse the tree
 	for {
 		if nodeIndex == 0 {
-			return found, ret
+			return found, retLength, ret
 		}
 		node := &t.nodes[nodeIndex]
 
 		matchCount := node.MatchCount(address)
 		if matchCount < node.prefixLength {
 			// didn't match the entire node - we're done
-			return found, ret
+			return found, retLength, ret
 		}
+		matchLength += matchCount
 
 		// matched the full node - get its tags, then chop off the bits we've already matched and continue
 		if node.TagCount > 0 {
 			ret = t.firstTagForNode(nodeIndex)
+			retLength = matchLength
 			found = true
 		}
 
 		if matchCount == address.Length {
 			// exact match - we're done
-			return found, ret
+			return found, retLength, ret
 		}
 
 		// there's still more address - keep traversing
@@ -651,9 +1014,37 @@ func (t *TreeV6) FindDeepestTagsAppend(ret []byte, address patricia.IPv6Address)
 // - appends results to the input slice
 // - returns true unless the tree is empty, even if the results are filtered out
 func (t *TreeV6) FindDeepestTagsWithFilterAppend(ret []byte, address patricia.IPv6Address, filterFunc FilterFunc) (bool, []byte) {
+	found, _, ret := t.findDeepestTags(ret, address, filterFunc)
+	return found, ret
+}
+
+// FindDeepestTagsWithPrefix finds all tags at the deepest level in the tree, representing the closest match,
+// along with the prefix of the node they were found at
+// - use FindDeepestTagsWithPrefixAppend if you can reuse slices, to cut down on allocations
+// - the returned prefix is only meaningful if tags were found
+func (t *TreeV6) FindDeepestTagsWithPrefix(address patricia.IPv6Address) (bool, patricia.IPv6Address, []byte) {
+	ret := make([]byte, 0)
+	return t.FindDeepestTagsWithPrefixAppend(ret, address)
+}
+
+// FindDeepestTagsWithPrefixAppend finds all tags at the deepest level in the tree, representing the closest match,
+// along with the prefix of the node they were found at
+// - appends results to the input slice
+// - the returned prefix is only meaningful if tags were found
+func (t *TreeV6) FindDeepestTagsWithPrefixAppend(ret []byte, address patricia.IPv6Address) (bool, patricia.IPv6Address, []byte) {
+	found, matchLength, ret := t.findDeepestTags(ret, address, nil)
+	return found, t.maskedAddress(address, matchLength), ret
+}
+
+// findDeepestTags finds all tags at the deepest level in the tree, matching the provided filter, and how many bits
+// of the address the node matched
+// - appends results to the input slice
+func (t *TreeV6) findDeepestTags(ret []byte, address patricia.IPv6Address, filterFunc FilterFunc) (bool, uint, []byte) {
 	root := &t.nodes[1]
 	var found bool
 	var retTagIndex uint
+	var retLength uint
+	var matchLength uint
 
 	if root.TagCount > 0 {
 		retTagIndex = 1
@@ -662,7 +1053,7 @@ func (t *TreeV6) FindDeepestTagsWithFilterAppend(ret []byte, address patricia.IP
 
 	if address.Length == 0 {
 		// caller just looking for root tags
-		return found, t.tagsForNode(ret, retTagIndex, filterFunc)
+		return found, retLength, t.tagsForNode(ret, retTagIndex, filterFunc)
 	}
 
 	var nodeIndex uint
@@ -675,25 +1066,27 @@ func (t *TreeV6) FindDeepestTagsWithFilterAppend(ret []byte, address patricia.IP
 	// traverse the tree
 	for {
 		if nodeIndex == 0 {
-			return found, t.tagsForNode(ret, retTagIndex, filterFunc)
+			return found, retLength, t.tagsForNode(ret, retTagIndex, filterFunc)
 		}
 		node := &t.nodes[nodeIndex]
 
 		matchCount := node.MatchCount(address)
 		if matchCount < node.prefixLength {
 			// didn't match the entire node - we're done
-			return found, t.tagsForNode(ret, retTagIndex, filterFunc)
+			return found, retLength, t.tagsForNode(ret, retTagIndex, filterFunc)
 		}
+		matchLength += matchCount
 
 		// matched the full node - get its tags, then chop off the bits we've already matched and continue
 		if node.TagCount > 0 {
 			retTagIndex = nodeIndex
+			retLength = matchLength
 			found = true
 		}
 
 		if matchCount == address.Length {
 			// exact match - we're done
-			return found, t.tagsForNode(ret, retTagIndex, filterFunc)
+			return found, retLength, t.tagsForNode(ret, retTagIndex, filterFunc)
 		}
 
 		// there's still more address - keep traversing
@@ -708,99 +1101,739 @@ func (t *TreeV6) FindDeepestTagsWithFilterAppend(ret []byte, address patricia.IP
 
 // TreeIteratorV6 is a stateful iterator over a tree.
 type TreeIteratorV6 struct {
-	t           *TreeV6
-	nodeIndex   uint
-	nodeHistory []uint
-	next        treeIteratorNext
+	t             *TreeV6
+	nodeIndex     uint
+	nodeHistory   []uint
+	address       patricia.IPv6Address // the full prefix of the node at nodeIndex
+	next          treeIteratorNext
+	start         patricia.IPv6Address // where the iteration begins, or the subtree to iterate if subtree is set
+	subtree       bool
+	subtreeDepth  int                  // length of nodeHistory at the root of the iterated subtree
+	upperBound    patricia.IPv6Address // iteration stops at this address, if hasUpperBound is set
+	hasUpperBound bool
+	reverse       bool // iterate from the last node to the first
 }
 
-// Iterate returns an iterator to find all nodes from a tree. It is
-// important for the tree to not be modified while using the iterator.
-func (t *TreeV6) Iterate() *TreeIteratorV6 {
-	return &TreeIteratorV6{
-		t:           t,
-		nodeIndex:   1,
-		nodeHistory: []uint{},
-		next:        nextSelf,
+// SetPrefix sets the single value for a node - overwrites what's there
+// - returns whether the tag count at this address was increased, and how many tags at this address
+// - returns an error if the prefix isn't valid for this tree
+func (t *TreeV6) SetPrefix(prefix netip.Prefix, tag byte) (bool, int, error) {
+	address, err := t.addressFromPrefix(prefix)
+	if err != nil {
+		return false, 0, err
 	}
+	countIncreased, count := t.Set(address, tag)
+	return countIncreased, count, nil
 }
 
-// Next jumps to the next element of a tree. It returns false if there
-// is none.
-func (iter *TreeIteratorV6) Next() bool {
-	for {
-		node := &iter.t.nodes[iter.nodeIndex]
-		if iter.next == nextSelf {
-			iter.next = nextLeft
-			if node.TagCount != 0 {
-				return true
-			}
-		}
-		if iter.next == nextLeft {
-			if node.Left != 0 {
-				iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
-				iter.nodeIndex = node.Left
-				iter.next = nextSelf
-			} else {
-				iter.next = nextRight
-			}
-		}
-		if iter.next == nextRight {
-			if node.Right != 0 {
-				iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
-				iter.nodeIndex = node.Right
-				iter.next = nextSelf
-			} else {
-				// We need to backtrack
-				iter.next = nextUp
-			}
-		}
-		if iter.next == nextUp {
-			nodeHistoryLen := len(iter.nodeHistory)
-			if nodeHistoryLen == 0 {
-				return false
-			}
-			previousIndex := iter.nodeHistory[nodeHistoryLen-1]
-			previousNode := iter.t.nodes[previousIndex]
-			iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
-			if previousNode.Left == iter.nodeIndex {
-				iter.nodeIndex = previousIndex
-				iter.next = nextRight
-			} else if previousNode.Right == iter.nodeIndex {
-				iter.nodeIndex = previousIndex
-				iter.next = nextUp
-			} else {
-				panic("unexpected state")
-			}
-		}
+// AddPrefix adds a tag to the tree
+// - if matchFunc is non-nil, it will be used to ensure uniqueness at this node
+// - returns whether the tag count at this address was increased, and how many tags at this address
+// - returns an error if the prefix isn't valid for this tree
+func (t *TreeV6) AddPrefix(prefix netip.Prefix, tag byte, matchFunc MatchesFunc) (bool, int, error) {
+	address, err := t.addressFromPrefix(prefix)
+	if err != nil {
+		return false, 0, err
 	}
+	countIncreased, count := t.Add(address, tag, matchFunc)
+	return countIncreased, count, nil
 }
 
-// Tags returns the current tags for the iterator. This is not a copy
-// and the result should not be used outside the iterator.
-func (iter *TreeIteratorV6) Tags() []byte {
-	return iter.TagsWithBuffer(nil)
+// DeletePrefix deletes a tag from the tree if it matches matchVal, as determined by matchFunc. Returns how many tags are removed
+// - returns an error if the prefix isn't valid for this tree
+func (t *TreeV6) DeletePrefix(prefix netip.Prefix, matchFunc MatchesFunc, matchVal byte) (int, error) {
+	address, err := t.addressFromPrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	return t.Delete(address, matchFunc, matchVal), nil
 }
 
-// TagsWithBuffer returns the current tags for the iterator. To avoid
-// allocation, it uses the provided buffer.
-func (iter *TreeIteratorV6) TagsWithBuffer(ret []byte) []byte {
-	return iter.t.tagsForNode(ret, uint(iter.nodeIndex), nil)
+// FindTagsAddr finds all matching tags for given address
+// - addresses that aren't valid for this tree have no tags
+// - use FindTagsAddrAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) FindTagsAddr(addr netip.Addr) []byte {
+	ret := make([]byte, 0)
+	return t.FindTagsAddrAppend(ret, addr)
 }
 
-// Delete a tag from the current node if it matches matchVal, as
-// determined by matchFunc. Returns how many tags are removed
-// - use DeleteWithBuffer if you can reuse slices, to cut down on allocations
-func (iter *TreeIteratorV6) Delete(matchFunc MatchesFunc, matchVal byte) int {
-	return iter.DeleteWithBuffer(nil, matchFunc, matchVal)
+// FindTagsAddrAppend finds all matching tags for given address and appends them to ret
+// - addresses that aren't valid for this tree have no tags
+func (t *TreeV6) FindTagsAddrAppend(ret []byte, addr netip.Addr) []byte {
+	address, err := t.addressFromAddr(addr)
+	if err != nil {
+		return ret
+	}
+	return t.FindTagsAppend(ret, address)
 }
 
-// DeleteWithBuffer a tag from the current node if it matches
-// matchVal, as determined by matchFunc. Returns how many tags are
-// removed
-// - uses input slice to reduce allocations
-func (iter *TreeIteratorV6) DeleteWithBuffer(buf []byte, matchFunc MatchesFunc, matchVal byte) int {
-	deleteCount, remainingTagCount := iter.t.deleteTag(buf, iter.nodeIndex, matchVal, matchFunc)
+// FindDeepestTagAddr finds a tag at the deepest level in the tree, representing the closest match.
+// - if that target node has multiple tags, the first in the list is returned
+// - addresses that aren't valid for this tree have no tags
+func (t *TreeV6) FindDeepestTagAddr(addr netip.Addr) (bool, byte) {
+	address, err := t.addressFromAddr(addr)
+	if err != nil {
+		var ret byte
+		return false, ret
+	}
+	return t.FindDeepestTag(address)
+}
+
+// FindDeepestTagsAddrAppend finds all tags at the deepest level in the tree, representing the closest match
+// - appends results to the input slice
+// - addresses that aren't valid for this tree have no tags
+func (t *TreeV6) FindDeepestTagsAddrAppend(ret []byte, addr netip.Addr) (bool, []byte) {
+	address, err := t.addressFromAddr(addr)
+	if err != nil {
+		return false, ret
+	}
+	return t.FindDeepestTagsAppend(ret, address)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the tree as a snapshot that UnmarshalBinary can load
+// - the encoding has a versioned header and a checksum, so corrupt or incompatible data is rejected
+// - returns patricia.ErrUnsupportedTagType if a tag isn't a bool, string, or fixed-size number
+func (t *TreeV6) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, binaryHeaderSize+len(t.nodes)*treeNodeV6BinarySize+len(t.availableIndexes)*4+binaryChecksumSize)
+	buf = append(buf, binaryMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, binaryVersion)
+	buf = append(buf, byte(t.addressBits()), t.tagKind())
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(t.nodes)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(t.availableIndexes)))
+
+	for i := range t.nodes {
+		buf = t.nodes[i].appendBinary(buf)
+	}
+	for _, index := range t.availableIndexes {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(index))
+	}
+
+	var err error
+	for i := range t.nodes {
+		key := uint64(i) << 32
+		for j := 0; j < t.nodes[i].TagCount; j++ {
+			buf, err = appendTagBinary(buf, t.tags[key+uint64(j)])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// tagKind returns the kind of tags in the binary encoding of the tree, or 0 if they can be of any kind
+func (t *TreeV6) tagKind() byte {
+	var tag byte
+	return tagKindOf(&tag)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, replacing the contents of the tree with data from MarshalBinary
+// - returns patricia.ErrInvalidBinary, patricia.ErrUnsupportedVersion or patricia.ErrChecksumMismatch if the data is malformed
+// - returns patricia.ErrIncompatibleBinary if the data is from a tree of another address family or tag type
+// - the tree is unchanged if an error is returned
+func (t *TreeV6) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize+binaryChecksumSize || string(data[:len(binaryMagic)]) != binaryMagic {
+		return patricia.ErrInvalidBinary
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version != binaryVersion {
+		return fmt.Errorf("%w: %d", patricia.ErrUnsupportedVersion, version)
+	}
+	checksumStart := len(data) - binaryChecksumSize
+	if crc32.ChecksumIEEE(data[:checksumStart]) != binary.BigEndian.Uint32(data[checksumStart:]) {
+		return patricia.ErrChecksumMismatch
+	}
+	if uint(data[6]) != t.addressBits() {
+		return fmt.Errorf("%w: %d-bit addresses", patricia.ErrIncompatibleBinary, data[6])
+	}
+	if kind := t.tagKind(); kind != 0 && data[7] != kind {
+		return fmt.Errorf("%w: tags of kind %d", patricia.ErrIncompatibleBinary, data[7])
+	}
+
+	nodeCount := uint64(binary.BigEndian.Uint32(data[8:]))
+	availableCount := uint64(binary.BigEndian.Uint32(data[12:]))
+	buf := data[binaryHeaderSize:checksumStart]
+	if nodeCount < 2 || uint64(len(buf)) < nodeCount*treeNodeV6BinarySize+availableCount*4 {
+		return patricia.ErrInvalidBinary
+	}
+
+	nodes := make([]treeNodeV6, nodeCount)
+	for i := range nodes {
+		buf = nodes[i].readBinary(buf)
+		if uint64(nodes[i].Left) >= nodeCount || uint64(nodes[i].Right) >= nodeCount || nodes[i].prefixLength > t.addressBits() {
+			return fmt.Errorf("%w: node %d is malformed", patricia.ErrInvalidBinary, i)
+		}
+	}
+	availableIndexes := make([]uint, availableCount)
+	for i := range availableIndexes {
+		index := uint64(binary.BigEndian.Uint32(buf))
+		if index < 2 || index >= nodeCount {
+			return fmt.Errorf("%w: available index %d is out of range", patricia.ErrInvalidBinary, index)
+		}
+		availableIndexes[i] = uint(index)
+		buf = buf[4:]
+	}
+
+	// the used nodes must form a tree under the root, apart from the available ones, so lookups can't loop, and each
+	// path must fit in an address, with left children starting with a 0 bit, and right children with a 1 bit
+	if nodes[1].prefixLength != 0 {
+		return fmt.Errorf("%w: root node is malformed", patricia.ErrInvalidBinary)
+	}
+	type stackEntry struct {
+		nodeIndex    uint
+		prefixLength uint // the length of the path down to and including the node
+		right        bool
+	}
+	used := make([]bool, nodeCount)
+	stack := []stackEntry{{nodeIndex: 1}}
+	for len(stack) > 0 {
+		entry := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &nodes[entry.nodeIndex]
+		if used[entry.nodeIndex] || entry.prefixLength > t.addressBits() ||
+			(entry.nodeIndex != 1 && (node.prefixLength == 0 || node.IsLeftBitSet() != entry.right)) {
+			return fmt.Errorf("%w: node %d is malformed", patricia.ErrInvalidBinary, entry.nodeIndex)
+		}
+		used[entry.nodeIndex] = true
+
+		if node.Left != 0 {
+			stack = append(stack, stackEntry{node.Left, entry.prefixLength + nodes[node.Left].prefixLength, false})
+		}
+		if node.Right != 0 {
+			stack = append(stack, stackEntry{node.Right, entry.prefixLength + nodes[node.Right].prefixLength, true})
+		}
+	}
+	for i := range nodes {
+		if !used[i] && nodes[i].TagCount != 0 {
+			return fmt.Errorf("%w: unused node %d has tags", patricia.ErrInvalidBinary, i)
+		}
+	}
+	for _, index := range availableIndexes {
+		if used[index] {
+			return fmt.Errorf("%w: available index %d is in use", patricia.ErrInvalidBinary, index)
+		}
+		used[index] = true
+	}
+
+	tags := make(map[uint64]byte)
+	for i := range nodes {
+		key := uint64(i) << 32
+		for j := 0; j < nodes[i].TagCount; j++ {
+			value, rest, err := readTagBinary(buf)
+			if err != nil {
+				return err
+			}
+			tag, ok := value.(byte)
+			if !ok {
+				return fmt.Errorf("%w: %T tags", patricia.ErrIncompatibleBinary, value)
+			}
+			tags[key+uint64(j)] = tag
+			buf = rest
+		}
+	}
+	if len(buf) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", patricia.ErrInvalidBinary, len(buf))
+	}
+
+	t.nodes = nodes
+	t.availableIndexes = availableIndexes
+	t.tags = tags
+	return nil
+}
+
+// LoadTSV adds the tags from r to the tree, returning how many were added
+// - each line is a prefix and a tag separated by a tab, like "10.0.0.0/8\tvalue" - the length defaults to the full address
+// - tags are parsed from their text as written by WriteTSV
+// - blank lines, and lines starting with '#', are skipped
+// - errors include the line number, and tags from the lines before it stay in the tree
+func (t *TreeV6) LoadTSV(r io.Reader) (int, error) {
+	return scanTSV(r, func(prefix string, text string) error {
+		address, err := t.parseAddress(prefix)
+		if err != nil {
+			return fmt.Errorf("prefix %q: %w", prefix, err)
+		}
+		var tag byte
+		if err := parseTagText(text, &tag); err != nil {
+			return fmt.Errorf("tag %q: %w", text, err)
+		}
+		t.Add(address, tag, nil)
+		return nil
+	})
+}
+
+// WriteTSV writes a line to w for each tag in the tree, in the order described in IterateFrom
+// - lines are written as LoadTSV reads them
+// - returns patricia.ErrMalformedLine for a string tag with a line break
+func (t *TreeV6) WriteTSV(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	var line []byte
+	var tags []byte
+	var err error
+	treeIter := t.Iterate()
+	for treeIter.Next() {
+		tags = treeIter.TagsWithBuffer(tags[:0])
+		for _, tag := range tags {
+			line = treeIter.Prefix().AppendTo(line[:0])
+			line = append(line, '\t')
+			line, err = appendTagText(line, tag)
+			if err != nil {
+				return err
+			}
+			line = append(line, '\n')
+			if _, err := writer.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// MarshalJSON implements json.Marshaler, encoding the tree as an object with a key for each prefix with tags,
+// like {"10.0.0.0/8":["a","b"]}
+// - keys are in the order described in IterateFrom, and tags are encoded with encoding/json
+func (t *TreeV6) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	var tags []byte
+	treeIter := t.Iterate()
+	for treeIter.Next() {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '"')
+		buf = treeIter.Prefix().AppendTo(buf)
+		buf = append(buf, '"', ':')
+
+		// tags are encoded one at a time, as []byte would be encoded as a string
+		tags = treeIter.TagsWithBuffer(tags[:0])
+		buf = append(buf, '[')
+		for i, tag := range tags {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			value, err := json.Marshal(tag)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, value...)
+		}
+		buf = append(buf, ']')
+	}
+	return append(buf, '}'), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, replacing the contents of the tree with JSON from MarshalJSON
+// - prefixes are parsed as in LoadTSV, and two keys can't be the same prefix, like "10.0.0.0/8" and "10.1.0.0/8"
+// - the tree is unchanged if an error is returned, or the JSON is null
+func (t *TreeV6) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var prefixes map[string][]json.RawMessage
+	if err := json.Unmarshal(data, &prefixes); err != nil {
+		return err
+	}
+
+	tree := NewTreeV6()
+	keys := make(map[patricia.IPv6Address]string, len(prefixes))
+	for prefix, values := range prefixes {
+		address, err := t.parseAddress(prefix)
+		if err != nil {
+			return fmt.Errorf("prefix %q: %w", prefix, err)
+		}
+		// keys are visited in random order, so the tags of keys for the same prefix would be in random order
+		if key, ok := keys[address]; ok {
+			return fmt.Errorf("prefix %q is the same as %q", prefix, key)
+		}
+		keys[address] = prefix
+
+		for _, value := range values {
+			var tag byte
+			if err := json.Unmarshal(value, &tag); err != nil {
+				return fmt.Errorf("prefix %q: %w", prefix, err)
+			}
+			tree.Add(address, tag, nil)
+		}
+	}
+	*t = *tree
+	return nil
+}
+
+// Iterate returns an iterator to find all nodes from a tree. It is
+// important for the tree to not be modified while using the iterator.
+func (t *TreeV6) Iterate() *TreeIteratorV6 {
+	iter := &TreeIteratorV6{
+		t: t,
+	}
+	iter.Reset()
+	return iter
+}
+
+// All returns an iterator over the addresses in the tree and their tags, in the
+// same order as Iterate(). The tags slice is a copy that can be kept by the caller.
+// It is important for the tree to not be modified while iterating.
+func (t *TreeV6) All() iter.Seq2[patricia.IPv6Address, []byte] {
+	return func(yield func(patricia.IPv6Address, []byte) bool) {
+		treeIter := t.Iterate()
+		for treeIter.Next() {
+			if !yield(treeIter.Address(), treeIter.TagsWithBuffer(nil)) {
+				return
+			}
+		}
+	}
+}
+
+// Prefixes returns an iterator over the addresses in the tree that have tags, in
+// the same order as Iterate(). It is important for the tree to not be modified
+// while iterating.
+func (t *TreeV6) Prefixes() iter.Seq[patricia.IPv6Address] {
+	return func(yield func(patricia.IPv6Address) bool) {
+		treeIter := t.Iterate()
+		for treeIter.Next() {
+			if !yield(treeIter.Address()) {
+				return
+			}
+		}
+	}
+}
+
+// Tags returns an iterator over every tag in the tree, in the same order as
+// Iterate(). It is important for the tree to not be modified while iterating.
+func (t *TreeV6) Tags() iter.Seq[byte] {
+	return func(yield func(byte) bool) {
+		treeIter := t.Iterate()
+		for treeIter.Next() {
+			tagCount := t.nodes[treeIter.nodeIndex].TagCount
+			key := uint64(treeIter.nodeIndex) << 32
+			for i := 0; i < tagCount; i++ {
+				if !yield(t.tags[key+uint64(i)]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// IterateReverse returns an iterator to find all nodes from a tree, in the
+// reverse order of Iterate(). It is important for the tree to not be modified
+// while using the iterator.
+func (t *TreeV6) IterateReverse() *TreeIteratorV6 {
+	iter := &TreeIteratorV6{
+		t:       t,
+		reverse: true,
+	}
+	iter.Reset()
+	return iter
+}
+
+// IterateFrom returns an iterator to find all nodes from a tree, starting at the
+// first node at or after the input address. Nodes are iterated in address order,
+// with a prefix coming before the more specific prefixes it covers. It is
+// important for the tree to not be modified while using the iterator.
+func (t *TreeV6) IterateFrom(from patricia.IPv6Address) *TreeIteratorV6 {
+	iter := &TreeIteratorV6{
+		t:     t,
+		start: t.maskedAddress(from, from.Length),
+	}
+	iter.Reset()
+	return iter
+}
+
+// IterateRange returns an iterator to find all nodes from a tree that are at or
+// after the from address, and before the to address, in the order described in
+// IterateFrom. It is important for the tree to not be modified while using the
+// iterator.
+func (t *TreeV6) IterateRange(from patricia.IPv6Address, to patricia.IPv6Address) *TreeIteratorV6 {
+	iter := &TreeIteratorV6{
+		t:             t,
+		start:         t.maskedAddress(from, from.Length),
+		upperBound:    t.maskedAddress(to, to.Length),
+		hasUpperBound: true,
+	}
+	iter.Reset()
+	return iter
+}
+
+// IterateSubtree returns an iterator over all nodes covered by the input
+// address, including the address itself. It is important for the tree
+// to not be modified while using the iterator.
+func (t *TreeV6) IterateSubtree(address patricia.IPv6Address) *TreeIteratorV6 {
+	iter := &TreeIteratorV6{
+		t:       t,
+		start:   address,
+		subtree: true,
+	}
+	iter.Reset()
+	return iter
+}
+
+// Reset rewinds the iterator to the beginning of its iteration, so that it
+// can be reused without allocating.
+func (iter *TreeIteratorV6) Reset() {
+	iter.nodeIndex = 1
+	iter.nodeHistory = iter.nodeHistory[:0]
+	iter.address = patricia.IPv6Address{}
+	iter.subtreeDepth = 0
+	if iter.reverse {
+		iter.next = nextRight
+	} else {
+		iter.next = nextSelf
+	}
+
+	if iter.subtree {
+		iter.seekSubtree(iter.start)
+	} else {
+		iter.seek(iter.start)
+	}
+}
+
+// seek positions the iterator so that Next() finds the first node at or after the input address
+func (iter *TreeIteratorV6) seek(address patricia.IPv6Address) {
+	if address.Length == 0 {
+		// the root node comes first
+		return
+	}
+
+	// the current node is before the address, and the address is somewhere in its subtree
+	for {
+		node := &iter.t.nodes[iter.nodeIndex]
+
+		var childIndex uint
+		if !address.IsLeftBitSet() {
+			if node.Left == 0 {
+				// everything to the right is after the address
+				iter.next = nextRight
+				return
+			}
+			childIndex = node.Left
+		} else {
+			if node.Right == 0 {
+				// everything below this node is before the address
+				iter.next = nextUp
+				return
+			}
+			childIndex = node.Right
+		}
+
+		iter.pushNode(childIndex)
+		child := &iter.t.nodes[childIndex]
+
+		matchCount := child.MatchCount(address)
+		if matchCount == address.Length {
+			// the address is a prefix of the child - the child and its subtree are at or after the address
+			iter.next = nextSelf
+			return
+		}
+
+		address.ShiftLeft(matchCount)
+		if matchCount < child.prefixLength {
+			// the child diverges from the address - the first different bit tells us which one comes first
+			if address.IsLeftBitSet() {
+				iter.next = nextUp
+			} else {
+				iter.next = nextSelf
+			}
+			return
+		}
+
+		// the child is a prefix of the address - keep traversing
+	}
+}
+
+// seekSubtree positions the iterator at the first node covered by the input address
+func (iter *TreeIteratorV6) seekSubtree(address patricia.IPv6Address) {
+	if address.Length == 0 {
+		// the whole tree
+		return
+	}
+
+	// traverse the tree to the first node covered by the address
+	for {
+		node := &iter.t.nodes[iter.nodeIndex]
+
+		var childIndex uint
+		if !address.IsLeftBitSet() {
+			childIndex = node.Left
+		} else {
+			childIndex = node.Right
+		}
+		if childIndex == 0 {
+			// nothing is covered
+			iter.stop()
+			return
+		}
+
+		iter.pushNode(childIndex)
+		child := &iter.t.nodes[childIndex]
+
+		matchCount := child.MatchCount(address)
+		if matchCount == address.Length {
+			// the rest of the address is a prefix of this node - its subtree is what we want
+			iter.subtreeDepth = len(iter.nodeHistory)
+			return
+		}
+		if matchCount < child.prefixLength {
+			// didn't match the entire node - nothing is covered
+			iter.stop()
+			return
+		}
+
+		// there's still more address - keep traversing
+		address.ShiftLeft(matchCount)
+	}
+}
+
+// stop makes all further calls to Next() return false
+func (iter *TreeIteratorV6) stop() {
+	iter.nodeIndex = 1
+	iter.nodeHistory = iter.nodeHistory[:0]
+	iter.address = patricia.IPv6Address{}
+	iter.subtreeDepth = 0
+	iter.next = nextUp
+}
+
+// FindSubtreeTags finds all tags for the input address and every more specific address below it
+// - use FindSubtreeTagsAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) FindSubtreeTags(address patricia.IPv6Address) []byte {
+	ret := make([]byte, 0)
+	return t.FindSubtreeTagsAppend(ret, address)
+}
+
+// FindSubtreeTagsAppend finds all tags for the input address and every more specific address below it
+// - results are appended to the input slice, in iteration order
+func (t *TreeV6) FindSubtreeTagsAppend(ret []byte, address patricia.IPv6Address) []byte {
+	iter := t.IterateSubtree(address)
+	for iter.Next() {
+		ret = iter.TagsWithBuffer(ret)
+	}
+	return ret
+}
+
+// Next jumps to the next element of a tree. It returns false if there
+// is none.
+func (iter *TreeIteratorV6) Next() bool {
+	if len(iter.nodeHistory) < iter.subtreeDepth {
+		// we've been moved above the subtree by a deletion
+		return false
+	}
+	if iter.reverse {
+		return iter.nextReverse()
+	}
+	for {
+		node := &iter.t.nodes[iter.nodeIndex]
+		if iter.next == nextSelf {
+			iter.next = nextLeft
+			if node.TagCount != 0 {
+				if iter.hasUpperBound && !iter.beforeUpperBound() {
+					// every node from here on is past the upper bound
+					iter.stop()
+					return false
+				}
+				return true
+			}
+		}
+		if iter.next == nextLeft {
+			if node.Left != 0 {
+				iter.pushNode(node.Left)
+				iter.next = nextSelf
+			} else {
+				iter.next = nextRight
+			}
+		}
+		if iter.next == nextRight {
+			if node.Right != 0 {
+				iter.pushNode(node.Right)
+				iter.next = nextSelf
+			} else {
+				// We need to backtrack
+				iter.next = nextUp
+			}
+		}
+		if iter.next == nextUp {
+			nodeHistoryLen := len(iter.nodeHistory)
+			if nodeHistoryLen <= iter.subtreeDepth {
+				return false
+			}
+			previousNode := &iter.t.nodes[iter.nodeHistory[nodeHistoryLen-1]]
+			if previousNode.Left == iter.nodeIndex {
+				iter.next = nextRight
+			} else if previousNode.Right == iter.nodeIndex {
+				iter.next = nextUp
+			} else {
+				panic("unexpected state")
+			}
+			iter.popNode()
+		}
+	}
+}
+
+// nextReverse jumps to the previous element of a tree: children are visited
+// right to left, before their parent. It returns false if there is none.
+func (iter *TreeIteratorV6) nextReverse() bool {
+	for {
+		node := &iter.t.nodes[iter.nodeIndex]
+		if iter.next == nextRight {
+			if node.Right != 0 {
+				iter.pushNode(node.Right)
+				continue
+			}
+			iter.next = nextLeft
+		}
+		if iter.next == nextLeft {
+			if node.Left != 0 {
+				iter.pushNode(node.Left)
+				iter.next = nextRight
+				continue
+			}
+			iter.next = nextSelf
+		}
+		if iter.next == nextSelf {
+			iter.next = nextUp
+			if node.TagCount != 0 {
+				return true
+			}
+		}
+		if iter.next == nextUp {
+			nodeHistoryLen := len(iter.nodeHistory)
+			if nodeHistoryLen <= iter.subtreeDepth {
+				return false
+			}
+			previousNode := &iter.t.nodes[iter.nodeHistory[nodeHistoryLen-1]]
+			if previousNode.Right == iter.nodeIndex {
+				iter.next = nextLeft
+			} else if previousNode.Left == iter.nodeIndex {
+				iter.next = nextSelf
+			} else {
+				panic("unexpected state")
+			}
+			iter.popNode()
+		}
+	}
+}
+
+// Tags returns the current tags for the iterator. This is not a copy
+// and the result should not be used outside the iterator.
+func (iter *TreeIteratorV6) Tags() []byte {
+	return iter.TagsWithBuffer(nil)
+}
+
+// TagsWithBuffer returns the current tags for the iterator. To avoid
+// allocation, it uses the provided buffer.
+func (iter *TreeIteratorV6) TagsWithBuffer(ret []byte) []byte {
+	return iter.t.tagsForNode(ret, uint(iter.nodeIndex), nil)
+}
+
+// Delete a tag from the current node if it matches matchVal, as
+// determined by matchFunc. Returns how many tags are removed
+// - use DeleteWithBuffer if you can reuse slices, to cut down on allocations
+func (iter *TreeIteratorV6) Delete(matchFunc MatchesFunc, matchVal byte) int {
+	return iter.DeleteWithBuffer(nil, matchFunc, matchVal)
+}
+
+// DeleteWithBuffer a tag from the current node if it matches
+// matchVal, as determined by matchFunc. Returns how many tags are
+// removed
+// - uses input slice to reduce allocations
+func (iter *TreeIteratorV6) DeleteWithBuffer(buf []byte, matchFunc MatchesFunc, matchVal byte) int {
+	deleteCount, remainingTagCount := iter.t.deleteTag(buf, iter.nodeIndex, matchVal, matchFunc)
 	if remainingTagCount > 0 || iter.nodeIndex == 1 {
 		return deleteCount
 	}
@@ -814,39 +1847,460 @@ func (iter *TreeIteratorV6) DeleteWithBuffer(buf []byte, matchFunc MatchesFunc,
 		wasLeft = true
 	}
 	result := iter.t.deleteNode(currentIndex, current, parentIndex, parent)
-	switch result {
-	case notDeleted:
+	if result == notDeleted {
 		return deleteCount
+	}
+	if iter.reverse {
+		iter.afterDeleteReverse(result, wasLeft, parentIndex, parent)
+	} else {
+		switch result {
+		case deletedNodeReplacedByChild:
+			// Continue with the child
+			if wasLeft {
+				iter.nodeIndex = parent.Left
+			} else {
+				iter.nodeIndex = parent.Right
+			}
+			iter.next = nextSelf
+		case deletedNodeParentReplacedBySibling:
+			iter.nodeIndex = parentIndex
+			iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
+			if wasLeft {
+				// Parent replaced by right sibling, to visit
+				iter.next = nextSelf
+			} else {
+				// Parent replaced by left sibling, already visited
+				iter.next = nextUp
+			}
+		case deletedNodeJustRemoved:
+			iter.nodeIndex = parentIndex
+			iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
+			if wasLeft {
+				// Visit our sibling
+				iter.next = nextRight
+			} else {
+				// Go up
+				iter.next = nextUp
+			}
+		}
+	}
+
+	// the prefixes of the nodes around the deleted one may have been merged
+	iter.updateAddress()
+	return deleteCount
+}
+
+// afterDeleteReverse updates the state of a reverse iterator after its current node was deleted
+func (iter *TreeIteratorV6) afterDeleteReverse(result deleteNodeResult, wasLeft bool, parentIndex uint, parent *treeNodeV6) {
+	nodeHistoryLen := len(iter.nodeHistory)
+	switch result {
 	case deletedNodeReplacedByChild:
-		// Continue with the child
+		// The child was already visited
 		if wasLeft {
 			iter.nodeIndex = parent.Left
 		} else {
 			iter.nodeIndex = parent.Right
 		}
-		iter.next = nextSelf
+		iter.next = nextUp
 	case deletedNodeParentReplacedBySibling:
 		iter.nodeIndex = parentIndex
 		iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
 		if wasLeft {
-			// Parent replaced by right sibling, to visit
-			iter.next = nextSelf
-		} else {
-			// Parent replaced by left sibling, already visited
+			// Parent replaced by right sibling, already visited
 			iter.next = nextUp
+		} else {
+			// Parent replaced by left sibling, to visit
+			iter.next = nextRight
 		}
 	case deletedNodeJustRemoved:
 		iter.nodeIndex = parentIndex
 		iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
 		if wasLeft {
+			// Visit the parent
+			iter.next = nextSelf
+		} else {
 			// Visit our sibling
-			iter.next = nextRight
+			iter.next = nextLeft
+		}
+	}
+}
+
+// Union returns a new tree with the tags from both trees
+// - only identical prefixes are merged: 10.0.0.0/8 in one tree and 10.1.0.0/16 in the other are both kept as they are
+// - if both trees have tags at the same address, resolveFunc determines the tags of the new tree
+// - if resolveFunc is nil, the tags of both trees are kept, starting with this tree's
+func (t *TreeV6) Union(other *TreeV6, resolveFunc ResolveFunc) *TreeV6 {
+	if resolveFunc == nil {
+		resolveFunc = func(left []byte, right []byte) []byte {
+			return append(append(make([]byte, 0, len(left)+len(right)), left...), right...)
+		}
+	}
+	return t.combine(other, true, true, resolveFunc)
+}
+
+// Intersect returns a new tree with the addresses that have tags in both trees
+// - only identical prefixes intersect: 10.0.0.0/8 in one tree doesn't keep 10.1.0.0/16 from the other, nor the other way around
+// - resolveFunc determines the tags of the new tree
+// - if resolveFunc is nil, the tags of this tree are kept
+func (t *TreeV6) Intersect(other *TreeV6, resolveFunc ResolveFunc) *TreeV6 {
+	if resolveFunc == nil {
+		resolveFunc = func(left []byte, right []byte) []byte {
+			return left
+		}
+	}
+	return t.combine(other, false, false, resolveFunc)
+}
+
+// Difference returns a new tree with the addresses that have tags in this tree, but not in the other one
+// - only identical prefixes are removed: 10.0.0.0/8 in the other tree doesn't remove 10.1.0.0/16 from this one
+// - nor are prefixes split: 10.1.0.0/16 in the other tree leaves all of 10.0.0.0/8 in this one
+// - if both trees have tags at the same address, resolveFunc determines the tags of the new tree
+// - if resolveFunc is nil, the address is left out of the new tree
+func (t *TreeV6) Difference(other *TreeV6, resolveFunc ResolveFunc) *TreeV6 {
+	if resolveFunc == nil {
+		resolveFunc = func(left []byte, right []byte) []byte {
+			return nil
+		}
+	}
+	return t.combine(other, true, false, resolveFunc)
+}
+
+// combine walks both trees in order, building a new tree from their tags
+// - keepLeft and keepRight determine whether addresses that only have tags in one of the trees are kept
+// - resolveFunc is called for addresses that have tags in both trees
+func (t *TreeV6) combine(other *TreeV6, keepLeft bool, keepRight bool, resolveFunc ResolveFunc) *TreeV6 {
+	ret := &TreeV6{
+		nodes:            make([]treeNodeV6, 2),
+		availableIndexes: make([]uint, 0),
+		tags:             make(map[uint64]byte),
+	}
+	leftBuf := make([]byte, 0)
+	rightBuf := make([]byte, 0)
+
+	leftIter := t.Iterate()
+	rightIter := other.Iterate()
+	hasLeft := leftIter.Next()
+	hasRight := rightIter.Next()
+	for hasLeft || hasRight {
+		var compare int
+		if !hasLeft {
+			compare = 1
+		} else if !hasRight {
+			compare = -1
 		} else {
-			// Go up
-			iter.next = nextUp
+			compare = leftIter.Address().Compare(rightIter.Address())
+		}
+
+		switch {
+		case compare < 0:
+			if keepLeft {
+				leftBuf = leftIter.TagsWithBuffer(leftBuf[:0])
+				ret.addTags(leftIter.Address(), leftBuf)
+			}
+			hasLeft = leftIter.Next()
+		case compare > 0:
+			if keepRight {
+				rightBuf = rightIter.TagsWithBuffer(rightBuf[:0])
+				ret.addTags(rightIter.Address(), rightBuf)
+			}
+			hasRight = rightIter.Next()
+		default:
+			leftBuf = leftIter.TagsWithBuffer(leftBuf[:0])
+			rightBuf = rightIter.TagsWithBuffer(rightBuf[:0])
+			ret.addTags(leftIter.Address(), resolveFunc(leftBuf, rightBuf))
+			hasLeft = leftIter.Next()
+			hasRight = rightIter.Next()
 		}
 	}
-	return deleteCount
+	return ret
+}
+
+// addTags adds all the input tags to the tree at the input address, without checking for duplicates
+func (t *TreeV6) addTags(address patricia.IPv6Address, tags []byte) {
+	for _, tag := range tags {
+		t.add(address, tag, nil, nil)
+	}
+}
+
+// DiffV6 is a difference between two trees at a single address
+type DiffV6 struct {
+	Type    DiffType
+	Address patricia.IPv6Address
+	OldTags []byte // tags in this tree - empty if the address was added
+	NewTags []byte // tags in the other tree - empty if the address was removed
+}
+
+// Diff returns an iterator over the addresses whose tags differ between this tree and the other one, in the same
+// order as Iterate(). Both trees are walked together, without copying their contents.
+// - matchFunc is used to compare tags: the order of the tags at an address doesn't matter
+// - it is important for the trees to not be modified while iterating
+func (t *TreeV6) Diff(other *TreeV6, matchFunc MatchesFunc) iter.Seq[DiffV6] {
+	return func(yield func(DiffV6) bool) {
+		oldIter := t.Iterate()
+		newIter := other.Iterate()
+		hasOld := oldIter.Next()
+		hasNew := newIter.Next()
+		for hasOld || hasNew {
+			var compare int
+			if !hasOld {
+				compare = 1
+			} else if !hasNew {
+				compare = -1
+			} else {
+				compare = oldIter.Address().Compare(newIter.Address())
+			}
+
+			switch {
+			case compare < 0:
+				if !yield(DiffV6{Type: DiffRemoved, Address: oldIter.Address(), OldTags: oldIter.TagsWithBuffer(nil)}) {
+					return
+				}
+				hasOld = oldIter.Next()
+			case compare > 0:
+				if !yield(DiffV6{Type: DiffAdded, Address: newIter.Address(), NewTags: newIter.TagsWithBuffer(nil)}) {
+					return
+				}
+				hasNew = newIter.Next()
+			default:
+				oldTags := oldIter.TagsWithBuffer(nil)
+				newTags := newIter.TagsWithBuffer(nil)
+				if !t.tagsMatch(oldTags, newTags, matchFunc) {
+					if !yield(DiffV6{Type: DiffChanged, Address: oldIter.Address(), OldTags: oldTags, NewTags: newTags}) {
+						return
+					}
+				}
+				hasOld = oldIter.Next()
+				hasNew = newIter.Next()
+			}
+		}
+	}
+}
+
+// Aggregate shrinks the tree without changing the deepest tags found for any full-length address:
+// - sibling prefixes with matching tags are merged into their common supernet, if it doesn't have tags of its own
+// - prefixes with the same tags as the closest covering prefix with tags are removed
+// - matchFunc is used to compare tags: the order of the tags at an address doesn't matter
+// - returns how many tagged prefixes were eliminated
+func (t *TreeV6) Aggregate(matchFunc MatchesFunc) int {
+	eliminated := 0
+	for {
+		eliminated += t.removeRedundantPrefixes(matchFunc)
+		merged := t.mergeSiblingPrefixes(matchFunc)
+		if merged == 0 {
+			return eliminated
+		}
+		eliminated += merged
+	}
+}
+
+// removeRedundantPrefixes removes the prefixes with the same tags as their closest covering prefix with tags
+// - returns how many prefixes were removed
+func (t *TreeV6) removeRedundantPrefixes(matchFunc MatchesFunc) int {
+	var redundant []patricia.IPv6Address
+	tagsBuf := make([]byte, 0)
+	parentTagsBuf := make([]byte, 0)
+
+	iter := t.Iterate()
+	for iter.Next() {
+		address := iter.Address()
+		if address.Length == 0 {
+			continue
+		}
+		found, _, parentTags := t.FindDeepestTagsWithPrefixAppend(parentTagsBuf[:0], t.maskedAddress(address, address.Length-1))
+		parentTagsBuf = parentTags
+		if !found {
+			continue
+		}
+		tagsBuf = iter.TagsWithBuffer(tagsBuf[:0])
+		if t.tagsMatch(tagsBuf, parentTags, matchFunc) {
+			redundant = append(redundant, address)
+		}
+	}
+
+	for _, address := range redundant {
+		t.deleteAllTags(tagsBuf, address)
+	}
+	return len(redundant)
+}
+
+// mergeSiblingPrefixes replaces pairs of sibling prefixes with matching tags by their common supernet, if it doesn't
+// have tags of its own
+// - returns how many prefixes were eliminated
+func (t *TreeV6) mergeSiblingPrefixes(matchFunc MatchesFunc) int {
+	var supernets []patricia.IPv6Address
+	tagsBuf := make([]byte, 0)
+	siblingTagsBuf := make([]byte, 0)
+
+	iter := t.Iterate()
+	for iter.Next() {
+		address := iter.Address()
+		if address.Length == 0 {
+			continue
+		}
+		supernet := t.maskedAddress(address, address.Length-1)
+		if address != t.childAddress(supernet, false) {
+			// only look at each pair once, from the left
+			continue
+		}
+		if found, _ := t.GetExactAppend(siblingTagsBuf[:0], supernet); found {
+			continue
+		}
+		found, siblingTags := t.GetExactAppend(siblingTagsBuf[:0], t.childAddress(supernet, true))
+		siblingTagsBuf = siblingTags
+		if !found {
+			continue
+		}
+		tagsBuf = iter.TagsWithBuffer(tagsBuf[:0])
+		if t.tagsMatch(tagsBuf, siblingTags, matchFunc) {
+			supernets = append(supernets, supernet)
+		}
+	}
+
+	for _, supernet := range supernets {
+		_, tagsBuf = t.GetExactAppend(tagsBuf[:0], t.childAddress(supernet, false))
+		t.deleteAllTags(siblingTagsBuf, t.childAddress(supernet, false))
+		t.deleteAllTags(siblingTagsBuf, t.childAddress(supernet, true))
+		t.addTags(supernet, tagsBuf)
+	}
+	return len(supernets)
+}
+
+// deleteAllTags removes all the tags at the input address
+// - uses input slice to reduce allocations
+func (t *TreeV6) deleteAllTags(buf []byte, address patricia.IPv6Address) int {
+	var matchVal byte
+	return t.DeleteWithBuffer(buf, address, func(byte, byte) bool { return true }, matchVal)
+}
+
+// tagsMatch returns whether both lists have the same tags, in any order, as determined by matchFunc
+// - each tag in b can only match one tag in a, so duplicates must appear the same number of times in both
+func (t *TreeV6) tagsMatch(a []byte, b []byte, matchFunc MatchesFunc) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	// tag lists are short, so avoid allocating for the usual case
+	var matchedBuf [64]bool
+	var matched []bool
+	if len(b) <= len(matchedBuf) {
+		matched = matchedBuf[:len(b)]
+	} else {
+		matched = make([]bool, len(b))
+	}
+	for _, tag := range a {
+		found := false
+		for i, other := range b {
+			if !matched[i] && matchFunc(tag, other) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Allocate finds the first unused prefix of the input length within pool, and tags it
+// - a prefix is unused if it doesn't overlap any tagged prefix more specific than pool - tags at or above pool are ignored
+// - returns whether a prefix was allocated, and the allocated prefix
+func (t *TreeV6) Allocate(pool patricia.IPv6Address, prefixLength uint, tag byte) (bool, patricia.IPv6Address) {
+	return t.allocate(pool, prefixLength, tag, false)
+}
+
+// AllocateBestFit finds the unused prefix of the input length within pool that's in the smallest free block, and tags it
+// - this keeps larger free blocks intact for larger allocations later on
+// - ties go to the first free block
+// - returns whether a prefix was allocated, and the allocated prefix
+func (t *TreeV6) AllocateBestFit(pool patricia.IPv6Address, prefixLength uint, tag byte) (bool, patricia.IPv6Address) {
+	return t.allocate(pool, prefixLength, tag, true)
+}
+
+// Release deletes all tags at the input prefix, making it available to Allocate again
+// - returns the number of tags deleted
+func (t *TreeV6) Release(prefix patricia.IPv6Address) int {
+	return t.deleteAllTags(nil, prefix)
+}
+
+func (t *TreeV6) allocate(pool patricia.IPv6Address, prefixLength uint, tag byte, bestFit bool) (bool, patricia.IPv6Address) {
+	if prefixLength < pool.Length || prefixLength > t.addressBits() {
+		return false, patricia.IPv6Address{}
+	}
+
+	var found bool
+	var ret patricia.IPv6Address
+	t.freeBlocks(t.maskedAddress(pool, pool.Length), prefixLength, func(block patricia.IPv6Address) bool {
+		if !found || block.Length > ret.Length {
+			found = true
+			ret = block
+		}
+		// first fit takes the first block it finds - best fit keeps looking for a block that's exactly the right size
+		return bestFit && ret.Length < prefixLength
+	})
+	if !found {
+		return false, patricia.IPv6Address{}
+	}
+
+	ret.Length = prefixLength
+	t.Add(ret, tag, nil)
+	return true, ret
+}
+
+// Gaps returns the minimal list of prefixes within the input address that aren't covered by any tagged prefix
+// more specific than it, in address order
+// - tags at or above the input address are ignored
+// - use GapsAppend if you can reuse slices, to cut down on allocations
+func (t *TreeV6) Gaps(within patricia.IPv6Address) []patricia.IPv6Address {
+	ret := make([]patricia.IPv6Address, 0)
+	return t.GapsAppend(ret, within)
+}
+
+// GapsAppend returns the minimal list of prefixes within the input address that aren't covered by any tagged prefix
+// more specific than it, in address order
+// - results are appended to the input slice
+func (t *TreeV6) GapsAppend(ret []patricia.IPv6Address, within patricia.IPv6Address) []patricia.IPv6Address {
+	t.freeBlocks(t.maskedAddress(within, within.Length), t.addressBits(), func(block patricia.IPv6Address) bool {
+		ret = append(ret, block)
+		return true
+	})
+	return ret
+}
+
+// freeBlocks calls blockFunc on each of the largest blocks within pool that don't overlap any tagged prefix more specific
+// than pool, in address order
+// - blocks are split no further than maxLength, so partially used blocks at that length aren't returned
+// - stops when blockFunc returns false
+func (t *TreeV6) freeBlocks(pool patricia.IPv6Address, maxLength uint, blockFunc func(patricia.IPv6Address) bool) {
+	treeIter := &TreeIteratorV6{
+		t:       t,
+		subtree: true,
+	}
+	t.visitFreeBlocks(treeIter, pool, pool.Length, maxLength, blockFunc)
+}
+
+// visitFreeBlocks splits block until its parts are either free or used, returning false once blockFunc asks to stop
+func (t *TreeV6) visitFreeBlocks(treeIter *TreeIteratorV6, block patricia.IPv6Address, poolLength uint, maxLength uint,
+	blockFunc func(patricia.IPv6Address) bool) bool {
+	treeIter.start = block
+	treeIter.Reset()
+	for treeIter.Next() {
+		length := treeIter.Address().Length
+		if length <= poolLength {
+			// the pool's own tags don't count
+			continue
+		}
+		if length == block.Length || block.Length >= maxLength {
+			// the whole block is in use
+			return true
+		}
+
+		// part of the block is in use - check each half
+		return t.visitFreeBlocks(treeIter, t.childAddress(block, false), poolLength, maxLength, blockFunc) &&
+			t.visitFreeBlocks(treeIter, t.childAddress(block, true), poolLength, maxLength, blockFunc)
+	}
+	return blockFunc(block)
 }
 
 // note: this is only used for unit testing
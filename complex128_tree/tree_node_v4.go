@@ -3,6 +3,7 @@
 package complex128_tree
 
 import (
+	"encoding/binary"
 	"math/bits"
 
 	"github.com/kentik/patricia"
@@ -10,6 +11,12 @@ import (
 
 const _leftmost32Bit = uint32(1 << 31)
 
+// treeNodeV4BinarySize is the size of a node in the binary encoding: Left, Right, prefix, prefixLength, TagCount
+const treeNodeV4BinarySize = 4 + 4 + 4 + 1 + 4
+
+// treeNodeV4FrozenSize is the size of a node in the frozen layout: the binary encoding, then the offset of its tags
+const treeNodeV4FrozenSize = treeNodeV4BinarySize + 8
+
 type treeNodeV4 struct {
 	Left         uint // left node index: 0 for not set
 	Right        uint // right node index: 0 for not set
@@ -49,3 +56,22 @@ func (n *treeNodeV4) IsLeftBitSet() bool {
 func (n *treeNodeV4) MergeFromNodes(left *treeNodeV4, right *treeNodeV4) {
 	n.prefix, n.prefixLength = patricia.MergePrefixes32(left.prefix, left.prefixLength, right.prefix, right.prefixLength)
 }
+
+// appendBinary appends the binary encoding of the node to buf
+func (n *treeNodeV4) appendBinary(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n.Left))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(n.Right))
+	buf = binary.BigEndian.AppendUint32(buf, n.prefix)
+	buf = append(buf, byte(n.prefixLength))
+	return binary.BigEndian.AppendUint32(buf, uint32(n.TagCount))
+}
+
+// readBinary reads the node from the first treeNodeV4BinarySize bytes of buf, returning the rest
+func (n *treeNodeV4) readBinary(buf []byte) []byte {
+	n.Left = uint(binary.BigEndian.Uint32(buf))
+	n.Right = uint(binary.BigEndian.Uint32(buf[4:]))
+	n.prefix = binary.BigEndian.Uint32(buf[8:])
+	n.prefixLength = uint(buf[12])
+	n.TagCount = int(binary.BigEndian.Uint32(buf[13:]))
+	return buf[treeNodeV4BinarySize:]
+}
@@ -2,6 +2,19 @@
 
 package complex128_tree
 
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/kentik/patricia"
+)
+
 // code common to the IPv4/IPv6 trees
 
 // MatchesFunc is called to check if tag data matches the input value
@@ -13,6 +26,34 @@ type FilterFunc func(payload complex128) bool
 // UpdatesFunc is called to update the tag value
 type UpdatesFunc func(payload complex128) complex128
 
+// ResolveFunc is called when two trees both have tags at the same address, returning the tags the resulting tree gets
+// - the input slices are only valid for the duration of the call
+type ResolveFunc func(left []complex128, right []complex128) []complex128
+
+// DiffType is the kind of difference found between two trees at an address
+type DiffType int
+
+const (
+	// DiffAdded means the address only has tags in the other tree
+	DiffAdded DiffType = iota
+	// DiffRemoved means the address only has tags in this tree
+	DiffRemoved
+	// DiffChanged means the address has different tags in both trees
+	DiffChanged
+)
+
+// OverlapType is how a prefix in the tree overlaps a queried address
+type OverlapType int
+
+const (
+	// OverlapCovers means the prefix is a supernet of the queried address
+	OverlapCovers OverlapType = iota
+	// OverlapExact means the prefix is the queried address
+	OverlapExact
+	// OverlapCovered means the prefix is a more-specific of the queried address
+	OverlapCovered
+)
+
 // treeIteratorNext is an indicator to know what Next() should return
 // for the current node.
 type treeIteratorNext int
@@ -33,3 +74,361 @@ const (
 	deletedNodeParentReplacedBySibling
 	deletedNodeJustRemoved
 )
+
+// the binary encoding of a tree is a header, the nodes, the available node indexes, the tags of each node in node
+// order, then a CRC-32 checksum of everything before it, all big-endian
+// - header: magic, version, address bits, tag kind - 0 if tags can be of any kind, node count, available index count
+const (
+	binaryMagic        = "PATR"
+	binaryVersion      = 1
+	binaryHeaderSize   = 4 + 2 + 1 + 1 + 4 + 4
+	binaryChecksumSize = 4
+)
+
+// the frozen layout of a tree is a header, the nodes, the tags, then a CRC-32 checksum of everything before it,
+// all big-endian, with offsets relative to the start of their section so the layout can be used from anywhere in memory
+// - header: magic, version, address bits, tag kind - as in the binary encoding, node count, size of the tags
+// - each node is its binary encoding, then the offset of its first tag, with the tags of a node stored together
+const (
+	frozenMagic      = "PATF"
+	frozenVersion    = 1
+	frozenHeaderSize = 4 + 2 + 1 + 1 + 4 + 8
+)
+
+// the kinds of tags in the binary encoding, which precede each encoded tag
+const (
+	tagKindBool byte = iota + 1
+	tagKindInt
+	tagKindInt8
+	tagKindInt16
+	tagKindInt32
+	tagKindInt64
+	tagKindUint
+	tagKindUint8
+	tagKindUint16
+	tagKindUint32
+	tagKindUint64
+	tagKindFloat32
+	tagKindFloat64
+	tagKindComplex64
+	tagKindComplex128
+	tagKindString
+)
+
+// tagKindOf returns the kind of tag that tagPtr points to, or 0 if it points to an interface, which can hold any kind
+func tagKindOf(tagPtr any) byte {
+	switch tagPtr.(type) {
+	case *bool:
+		return tagKindBool
+	case *int:
+		return tagKindInt
+	case *int8:
+		return tagKindInt8
+	case *int16:
+		return tagKindInt16
+	case *int32:
+		return tagKindInt32
+	case *int64:
+		return tagKindInt64
+	case *uint:
+		return tagKindUint
+	case *uint8:
+		return tagKindUint8
+	case *uint16:
+		return tagKindUint16
+	case *uint32:
+		return tagKindUint32
+	case *uint64:
+		return tagKindUint64
+	case *float32:
+		return tagKindFloat32
+	case *float64:
+		return tagKindFloat64
+	case *complex64:
+		return tagKindComplex64
+	case *complex128:
+		return tagKindComplex128
+	case *string:
+		return tagKindString
+	}
+	return 0
+}
+
+// appendTagBinary appends the binary encoding of the tag to buf: its kind, then its value
+// - ints and uints are encoded as 64 bits, and strings are prefixed with their 32-bit length
+func appendTagBinary(buf []byte, tag any) ([]byte, error) {
+	switch v := tag.(type) {
+	case bool:
+		var b byte
+		if v {
+			b = 1
+		}
+		return append(buf, tagKindBool, b), nil
+	case int:
+		return binary.BigEndian.AppendUint64(append(buf, tagKindInt), uint64(v)), nil
+	case int8:
+		return append(buf, tagKindInt8, byte(v)), nil
+	case int16:
+		return binary.BigEndian.AppendUint16(append(buf, tagKindInt16), uint16(v)), nil
+	case int32:
+		return binary.BigEndian.AppendUint32(append(buf, tagKindInt32), uint32(v)), nil
+	case int64:
+		return binary.BigEndian.AppendUint64(append(buf, tagKindInt64), uint64(v)), nil
+	case uint:
+		return binary.BigEndian.AppendUint64(append(buf, tagKindUint), uint64(v)), nil
+	case uint8:
+		return append(buf, tagKindUint8, v), nil
+	case uint16:
+		return binary.BigEndian.AppendUint16(append(buf, tagKindUint16), v), nil
+	case uint32:
+		return binary.BigEndian.AppendUint32(append(buf, tagKindUint32), v), nil
+	case uint64:
+		return binary.BigEndian.AppendUint64(append(buf, tagKindUint64), v), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(append(buf, tagKindFloat32), math.Float32bits(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, tagKindFloat64), math.Float64bits(v)), nil
+	case complex64:
+		buf = binary.BigEndian.AppendUint32(append(buf, tagKindComplex64), math.Float32bits(real(v)))
+		return binary.BigEndian.AppendUint32(buf, math.Float32bits(imag(v))), nil
+	case complex128:
+		buf = binary.BigEndian.AppendUint64(append(buf, tagKindComplex128), math.Float64bits(real(v)))
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(imag(v))), nil
+	case string:
+		if uint64(len(v)) > math.MaxUint32 {
+			return buf, fmt.Errorf("%w: string of length %d", patricia.ErrUnsupportedTagType, len(v))
+		}
+		buf = binary.BigEndian.AppendUint32(append(buf, tagKindString), uint32(len(v)))
+		return append(buf, v...), nil
+	}
+	return buf, fmt.Errorf("%w: %T", patricia.ErrUnsupportedTagType, tag)
+}
+
+// tagBinarySize checks the tag encoded by appendTagBinary at the start of buf, without decoding it, returning its kind
+// and the size of its encoding
+func tagBinarySize(buf []byte) (byte, int, error) {
+	if len(buf) == 0 {
+		return 0, 0, patricia.ErrInvalidBinary
+	}
+	kind := buf[0]
+
+	var size int
+	switch kind {
+	case tagKindBool, tagKindInt8, tagKindUint8:
+		size = 1
+	case tagKindInt16, tagKindUint16:
+		size = 2
+	case tagKindInt32, tagKindUint32, tagKindFloat32, tagKindString:
+		size = 4
+	case tagKindInt, tagKindInt64, tagKindUint, tagKindUint64, tagKindFloat64, tagKindComplex64:
+		size = 8
+	case tagKindComplex128:
+		size = 16
+	default:
+		return kind, 0, fmt.Errorf("%w: unknown tag kind %d", patricia.ErrInvalidBinary, kind)
+	}
+	if len(buf) < 1+size {
+		return kind, 0, patricia.ErrInvalidBinary
+	}
+
+	switch kind {
+	case tagKindBool:
+		if buf[1] > 1 {
+			return kind, 0, fmt.Errorf("%w: invalid bool %d", patricia.ErrInvalidBinary, buf[1])
+		}
+	case tagKindString:
+		length := uint64(binary.BigEndian.Uint32(buf[1:]))
+		if uint64(len(buf)) < uint64(1+size)+length {
+			return kind, 0, patricia.ErrInvalidBinary
+		}
+		size += int(length)
+	}
+	return kind, 1 + size, nil
+}
+
+// readTagBinary reads a tag encoded by appendTagBinary from the start of buf, returning it and the rest of buf
+func readTagBinary(buf []byte) (any, []byte, error) {
+	kind, size, err := tagBinarySize(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	value := buf[1:size]
+	buf = buf[size:]
+
+	switch kind {
+	case tagKindBool:
+		return value[0] == 1, buf, nil
+	case tagKindInt:
+		return int(binary.BigEndian.Uint64(value)), buf, nil
+	case tagKindInt8:
+		return int8(value[0]), buf, nil
+	case tagKindInt16:
+		return int16(binary.BigEndian.Uint16(value)), buf, nil
+	case tagKindInt32:
+		return int32(binary.BigEndian.Uint32(value)), buf, nil
+	case tagKindInt64:
+		return int64(binary.BigEndian.Uint64(value)), buf, nil
+	case tagKindUint:
+		return uint(binary.BigEndian.Uint64(value)), buf, nil
+	case tagKindUint8:
+		return value[0], buf, nil
+	case tagKindUint16:
+		return binary.BigEndian.Uint16(value), buf, nil
+	case tagKindUint32:
+		return binary.BigEndian.Uint32(value), buf, nil
+	case tagKindUint64:
+		return binary.BigEndian.Uint64(value), buf, nil
+	case tagKindFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(value)), buf, nil
+	case tagKindFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), buf, nil
+	case tagKindComplex64:
+		return complex(math.Float32frombits(binary.BigEndian.Uint32(value)), math.Float32frombits(binary.BigEndian.Uint32(value[4:]))), buf, nil
+	case tagKindComplex128:
+		return complex(math.Float64frombits(binary.BigEndian.Uint64(value)), math.Float64frombits(binary.BigEndian.Uint64(value[8:]))), buf, nil
+	}
+
+	// tagKindString
+	return string(value[4:]), buf, nil
+}
+
+// scanTSV calls lineFunc with the prefix and tag text of each line of r, returning how many lines it was called for
+// - lines are a prefix and a tag separated by a tab, and the tag is everything after the first tab
+// - blank lines, and lines starting with '#', are skipped
+// - errors are returned with the number of the line they're on
+func scanTSV(r io.Reader, lineFunc func(prefix string, tag string) error) (int, error) {
+	scanner := bufio.NewScanner(r)
+	var lineNumber, count int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		prefix, tag, found := strings.Cut(line, "\t")
+		if !found {
+			return count, fmt.Errorf("line %d: %w: no tab", lineNumber, patricia.ErrMalformedLine)
+		}
+		if err := lineFunc(prefix, tag); err != nil {
+			return count, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("line %d: %w", lineNumber+1, err)
+	}
+	return count, nil
+}
+
+// appendTagText appends the text of the tag to buf, as written to TSV lines
+// - returns patricia.ErrMalformedLine for a string with a line break
+func appendTagText(buf []byte, tag any) ([]byte, error) {
+	switch v := tag.(type) {
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int8:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint8:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint16:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case float32:
+		return strconv.AppendFloat(buf, float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), nil
+	case complex64:
+		return append(buf, strconv.FormatComplex(complex128(v), 'g', -1, 64)...), nil
+	case complex128:
+		return append(buf, strconv.FormatComplex(v, 'g', -1, 128)...), nil
+	case string:
+		if strings.ContainsAny(v, "\r\n") {
+			return buf, fmt.Errorf("%w: tag %q has a line break", patricia.ErrMalformedLine, v)
+		}
+		return append(buf, v...), nil
+	}
+	return buf, fmt.Errorf("%w: %T", patricia.ErrUnsupportedTagType, tag)
+}
+
+// parseTagText parses text written by appendTagText into tag, which must be a pointer to a tag
+// - a tag that's an interface is set to the text
+func parseTagText(text string, tag any) error {
+	var err error
+	switch p := tag.(type) {
+	case *bool:
+		*p, err = strconv.ParseBool(text)
+	case *int:
+		var v int64
+		v, err = strconv.ParseInt(text, 10, 0)
+		*p = int(v)
+	case *int8:
+		var v int64
+		v, err = strconv.ParseInt(text, 10, 8)
+		*p = int8(v)
+	case *int16:
+		var v int64
+		v, err = strconv.ParseInt(text, 10, 16)
+		*p = int16(v)
+	case *int32:
+		var v int64
+		v, err = strconv.ParseInt(text, 10, 32)
+		*p = int32(v)
+	case *int64:
+		*p, err = strconv.ParseInt(text, 10, 64)
+	case *uint:
+		var v uint64
+		v, err = strconv.ParseUint(text, 10, 0)
+		*p = uint(v)
+	case *uint8:
+		var v uint64
+		v, err = strconv.ParseUint(text, 10, 8)
+		*p = uint8(v)
+	case *uint16:
+		var v uint64
+		v, err = strconv.ParseUint(text, 10, 16)
+		*p = uint16(v)
+	case *uint32:
+		var v uint64
+		v, err = strconv.ParseUint(text, 10, 32)
+		*p = uint32(v)
+	case *uint64:
+		*p, err = strconv.ParseUint(text, 10, 64)
+	case *float32:
+		var v float64
+		v, err = strconv.ParseFloat(text, 32)
+		*p = float32(v)
+	case *float64:
+		*p, err = strconv.ParseFloat(text, 64)
+	case *complex64:
+		var v complex128
+		v, err = strconv.ParseComplex(text, 64)
+		*p = complex64(v)
+	case *complex128:
+		*p, err = strconv.ParseComplex(text, 128)
+	case *string:
+		*p = text
+	default:
+		// an interface, like the template's complex128
+		value := reflect.ValueOf(tag)
+		if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Interface ||
+			!reflect.TypeOf(text).AssignableTo(value.Elem().Type()) {
+			return fmt.Errorf("%w: %T", patricia.ErrUnsupportedTagType, tag)
+		}
+		value.Elem().Set(reflect.ValueOf(text))
+	}
+	return err
+}
@@ -3,7 +3,9 @@
 package complex64_tree
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net/netip"
 
 	"github.com/kentik/patricia"
 )
@@ -24,8 +26,100 @@ func (t *TreeV6) newNode(address patricia.IPv6Address, prefixLength uint) uint {
 	return uint(len(t.nodes) - 1)
 }
 
-// Address returns the current IP address for the iterator.
-func (iter *TreeIteratorV6) Address() patricia.IPv6Address {
+// return the input address truncated to the input prefix length, with the remaining bits cleared
+func (t *TreeV6) maskedAddress(address patricia.IPv6Address, prefixLength uint) patricia.IPv6Address {
+	left, right, _ := patricia.MergePrefixes64(address.Left, address.Right, prefixLength, 0, 0, 0)
+	return patricia.IPv6Address{
+		Left:   left,
+		Right:  right,
+		Length: prefixLength,
+	}
+}
+
+// return the input prefix as an IPv6 address
+// - host bits past the prefix length are cleared, so 2001:db8::1/32 is 2001:db8::/32
+// - returns an error if it's not a valid IPv6 prefix
+func (t *TreeV6) addressFromPrefix(prefix netip.Prefix) (patricia.IPv6Address, error) {
+	if !prefix.IsValid() {
+		return patricia.IPv6Address{}, patricia.ErrInvalidAddress
+	}
+	address, err := t.addressFromAddr(prefix.Masked().Addr())
+	address.Length = uint(prefix.Bits())
+	return address, err
+}
+
+// return the input address as a full-length IPv6 address
+// - returns an error if it's not a valid IPv6 address
+func (t *TreeV6) addressFromAddr(addr netip.Addr) (patricia.IPv6Address, error) {
+	if !addr.IsValid() {
+		return patricia.IPv6Address{}, patricia.ErrInvalidAddress
+	}
+	if !addr.Is6() {
+		return patricia.IPv6Address{}, patricia.ErrAddressFamily
+	}
+	ip := addr.As16()
+	return patricia.NewIPv6AddressFromHalves(binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:]), 128), nil
+}
+
+// parse the input text as an IPv6 prefix, with an optional length, as written in TSV lines
+// - returns patricia.ErrAddressFamily for an IPv4 prefix
+func (t *TreeV6) parseAddress(text string) (patricia.IPv6Address, error) {
+	_, address, isV4, err := patricia.ParsePrefix(text)
+	if err == nil && isV4 {
+		err = patricia.ErrAddressFamily
+	}
+	return address, err
+}
+
+// return the number of bits in an address
+func (t *TreeV6) addressBits() uint {
+	return 128
+}
+
+// return the number of bits in an address
+func (t *FrozenTreeV6) addressBits() uint {
+	return 128
+}
+
+// return the minimal set of prefixes covering start to end, inclusive
+func (t *TreeV6) rangeToPrefixes(start patricia.IPv6Address, end patricia.IPv6Address) []patricia.IPv6Address {
+	return patricia.RangeToPrefixesV6(start, end)
+}
+
+// return the input address extended by one bit, which is set if right is true
+func (t *TreeV6) childAddress(address patricia.IPv6Address, right bool) patricia.IPv6Address {
+	var bit uint64
+	if right {
+		bit = uint64(1 << 63)
+	}
+	left, rightBits, prefixLength := patricia.MergePrefixes64(address.Left, address.Right, address.Length, bit, 0, 1)
+	return patricia.IPv6Address{
+		Left:   left,
+		Right:  rightBits,
+		Length: prefixLength,
+	}
+}
+
+// pushNode moves the iterator down to the input child of its current node
+func (iter *TreeIteratorV6) pushNode(childIndex uint) {
+	child := &iter.t.nodes[childIndex]
+	iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
+	iter.nodeIndex = childIndex
+	iter.address.Left, iter.address.Right, iter.address.Length = patricia.MergePrefixes64(iter.address.Left, iter.address.Right, iter.address.Length,
+		child.prefixLeft, child.prefixRight, child.prefixLength)
+}
+
+// popNode moves the iterator up to the parent of its current node
+func (iter *TreeIteratorV6) popNode() {
+	nodeHistoryLen := len(iter.nodeHistory)
+	iter.address.Length -= iter.t.nodes[iter.nodeIndex].prefixLength
+	iter.address.Left, iter.address.Right, _ = patricia.MergePrefixes64(iter.address.Left, iter.address.Right, iter.address.Length, 0, 0, 0)
+	iter.nodeIndex = iter.nodeHistory[nodeHistoryLen-1]
+	iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
+}
+
+// updateAddress rebuilds the current IP address for the iterator from its node history
+func (iter *TreeIteratorV6) updateAddress() {
 	var prefixLeft, prefixRight uint64
 	var prefixLength uint
 	for _, i := range iter.nodeHistory {
@@ -35,13 +129,28 @@ func (iter *TreeIteratorV6) Address() patricia.IPv6Address {
 	prefixLeft, prefixRight, prefixLength = patricia.MergePrefixes64(prefixLeft, prefixRight, prefixLength,
 		iter.t.nodes[iter.nodeIndex].prefixLeft, iter.t.nodes[iter.nodeIndex].prefixRight,
 		iter.t.nodes[iter.nodeIndex].prefixLength)
-	return patricia.IPv6Address{
+	iter.address = patricia.IPv6Address{
 		Left:   prefixLeft,
 		Right:  prefixRight,
 		Length: prefixLength,
 	}
 }
 
+// Address returns the current IP address for the iterator.
+func (iter *TreeIteratorV6) Address() patricia.IPv6Address {
+	return iter.address
+}
+
+// Prefix returns the current IP address for the iterator, as a netip.Prefix.
+func (iter *TreeIteratorV6) Prefix() netip.Prefix {
+	return iter.address.NetIPPrefix()
+}
+
+// beforeUpperBound returns whether the current IP address for the iterator is before its upper bound
+func (iter *TreeIteratorV6) beforeUpperBound() bool {
+	return iter.address.Compare(iter.upperBound) < 0
+}
+
 //nolint
 func (t *TreeV6) print() {
 	buf := make([]complex64, 0)
@@ -0,0 +1,121 @@
+package generics_tree
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/kentik/patricia"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDualStackTree(t *testing.T) {
+	tree := NewDualStackTree[string]()
+
+	countIncreased, count, err := tree.Add(netip.MustParsePrefix("10.0.0.0/8"), "A", nil)
+	assert.NoError(t, err)
+	assert.True(t, countIncreased)
+	assert.Equal(t, 1, count)
+	_, _, err = tree.Add(netip.MustParsePrefix("10.1.0.0/16"), "B", nil)
+	assert.NoError(t, err)
+	_, _, err = tree.Add(netip.MustParsePrefix("2001:db8::/32"), "C", nil)
+	assert.NoError(t, err)
+	_, _, err = tree.Set(netip.MustParsePrefix("2001:db8:1::/48"), "D")
+	assert.NoError(t, err)
+	_, _, err = tree.Add(netip.MustParsePrefix("::ffff:10.0.0.0/104"), "E", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, tree.CountTags())
+	assert.Equal(t, 2, tree.V4().CountTags())
+	assert.Equal(t, 3, tree.V6().CountTags())
+
+	_, _, err = tree.Add(netip.Prefix{}, "F", nil)
+	assert.ErrorIs(t, err, patricia.ErrInvalidAddress)
+	assert.Equal(t, 5, tree.CountTags())
+
+	tags, err := tree.FindTags(netip.MustParsePrefix("10.1.2.3/32"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B"}, tags)
+	tags, err = tree.FindTags(netip.MustParsePrefix("2001:db8:1::1/128"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C", "D"}, tags)
+	_, err = tree.FindTags(netip.Prefix{})
+	assert.Error(t, err)
+
+	found, tag, err := tree.FindDeepestTag(netip.MustParsePrefix("10.2.0.0/16"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "A", tag)
+	found, tag, err = tree.FindDeepestTag(netip.MustParsePrefix("::ffff:10.1.1.1/128"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "E", tag)
+	found, _, err = tree.FindDeepestTag(netip.MustParsePrefix("192.168.0.1/32"))
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	found, tags, err = tree.FindDeepestTags(netip.MustParsePrefix("2001:db8:2::/48"))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []string{"C"}, tags)
+
+	// IPv4 first, then IPv6
+	prefixes := []string{}
+	for prefix, tags := range tree.All() {
+		assert.Equal(t, 1, len(tags))
+		prefixes = append(prefixes, prefix.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "10.1.0.0/16", "::ffff:10.0.0.0/104", "2001:db8::/32", "2001:db8:1::/48"}, prefixes)
+
+	// resetting gets us the same results again
+	treeIter := tree.Iterate()
+	for treeIter.Next() {
+	}
+	treeIter.Reset()
+	assert.True(t, treeIter.Next())
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), treeIter.Prefix())
+	assert.Equal(t, []string{"A"}, treeIter.Tags())
+
+	matchAll := func(string, string) bool { return true }
+	deleted, err := tree.Delete(netip.MustParsePrefix("10.0.0.0/8"), matchAll, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	deleted, err = tree.Delete(netip.MustParsePrefix("2001:db8::/32"), matchAll, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = tree.Delete(netip.Prefix{}, matchAll, "")
+	assert.Error(t, err)
+	assert.Equal(t, 3, tree.CountTags())
+
+	clone := tree.Clone()
+	tree.Delete(netip.MustParsePrefix("10.1.0.0/16"), matchAll, "")
+	assert.Equal(t, 3, clone.CountTags())
+	assert.Equal(t, 2, tree.CountTags())
+}
+
+func TestDualStackTreeAllocations(t *testing.T) {
+	tree := NewDualStackTree[string]()
+	tree.Add(netip.MustParsePrefix("10.0.0.0/8"), "A", nil)
+	tree.Add(netip.MustParsePrefix("2001:db8::/32"), "B", nil)
+
+	v4 := netip.MustParsePrefix("10.1.2.3/32")
+	v6 := netip.MustParsePrefix("2001:db8::1/128")
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		tree.FindDeepestTag(v4)
+		tree.FindDeepestTag(v6)
+	}))
+}
+
+func TestDualStackTreeTSV(t *testing.T) {
+	tree := NewDualStackTree[string]()
+	count, err := tree.LoadTSV(strings.NewReader("2001:db8::/32\tA\n10.0.0.0/8\tB\n10.1.0.0/16\tC\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	var buf strings.Builder
+	assert.NoError(t, tree.WriteTSV(&buf))
+	assert.Equal(t, "10.0.0.0/8\tB\n10.1.0.0/16\tC\n2001:db8::/32\tA\n", buf.String())
+
+	_, err = tree.LoadTSV(strings.NewReader("10.0.0.0/8\tB\nnot a prefix\tD\n"))
+	assert.ErrorIs(t, err, patricia.ErrInvalidIPv4Address)
+	assert.Contains(t, err.Error(), "line 2")
+}
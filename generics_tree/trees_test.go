@@ -0,0 +1,73 @@
+package generics_tree
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kentik/patricia"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagBinary(t *testing.T) {
+	tags := []any{
+		true, false, -5, int8(-6), int16(-7), int32(-8), int64(-9),
+		uint(5), uint8(6), uint16(7), uint32(8), uint64(1 << 63),
+		float32(1.5), float64(-2.25), complex64(1 + 2i), complex128(-3 - 4i),
+		"", "hello",
+	}
+
+	var buf []byte
+	var err error
+	for _, tag := range tags {
+		buf, err = appendTagBinary(buf, tag)
+		assert.NoError(t, err)
+	}
+
+	for _, expected := range tags {
+		var tag any
+		tag, buf, err = readTagBinary(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, tag)
+	}
+	assert.Empty(t, buf)
+
+	_, err = appendTagBinary(nil, struct{}{})
+	assert.ErrorIs(t, err, patricia.ErrUnsupportedTagType)
+	_, _, err = readTagBinary([]byte{tagKindInt64, 0, 0, 0})
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	_, _, err = readTagBinary([]byte{tagKindString, 0, 0, 0, 5, 'a'})
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	_, _, err = readTagBinary([]byte{tagKindBool, 2})
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	_, _, err = readTagBinary([]byte{0xff})
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+}
+
+func TestTagText(t *testing.T) {
+	tags := []any{
+		true, -5, int8(-6), int16(-7), int32(-8), int64(-9),
+		uint(5), uint8(6), uint16(7), uint32(8), uint64(1 << 63),
+		float32(1.1), float64(-2.2), complex64(1 + 2i), complex128(-3.5 - 4i),
+		"", "hello\tthere",
+	}
+	for _, expected := range tags {
+		text, err := appendTagText(nil, expected)
+		assert.NoError(t, err)
+
+		tag := reflect.New(reflect.TypeOf(expected))
+		assert.NoError(t, parseTagText(string(text), tag.Interface()))
+		assert.Equal(t, expected, tag.Elem().Interface())
+	}
+
+	var anyTag any
+	assert.NoError(t, parseTagText("text", &anyTag))
+	assert.Equal(t, "text", anyTag)
+
+	var intTag int8
+	assert.Error(t, parseTagText("300", &intTag))
+	var boolTag bool
+	assert.Error(t, parseTagText("maybe", &boolTag))
+	assert.ErrorIs(t, parseTagText("text", &struct{}{}), patricia.ErrUnsupportedTagType)
+	_, err := appendTagText(nil, "line\nbreak")
+	assert.ErrorIs(t, err, patricia.ErrMalformedLine)
+}
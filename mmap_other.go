@@ -0,0 +1,18 @@
+//go:build !unix
+
+package patricia
+
+import (
+	"os"
+)
+
+// MapFile reads the input file into memory, for use with the NewFrozenTree functions
+// - memory mapping isn't supported on this platform, so the file is read onto the heap
+func MapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// UnmapFile releases memory returned by MapFile
+func UnmapFile(data []byte) error {
+	return nil
+}
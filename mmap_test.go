@@ -0,0 +1,29 @@
+package patricia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	assert.NoError(t, os.WriteFile(path, []byte("frozen tree"), 0o644))
+
+	data, err := MapFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("frozen tree"), data)
+	assert.NoError(t, UnmapFile(data))
+
+	emptyPath := filepath.Join(t.TempDir(), "empty")
+	assert.NoError(t, os.WriteFile(emptyPath, nil, 0o644))
+	data, err = MapFile(emptyPath)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+	assert.NoError(t, UnmapFile(data))
+
+	_, err = MapFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
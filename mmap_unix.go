@@ -0,0 +1,36 @@
+//go:build unix
+
+package patricia
+
+import (
+	"os"
+	"syscall"
+)
+
+// MapFile maps the input file into memory read-only, for use with the NewFrozenTree functions
+// - the memory is shared with every other process mapping the file, and isn't on the Go heap
+// - the returned slice must not be used after UnmapFile is called on it
+func MapFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return []byte{}, nil
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// UnmapFile releases memory returned by MapFile
+func UnmapFile(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
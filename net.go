@@ -1,6 +1,7 @@
 package patricia
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -8,7 +9,40 @@ import (
 	"strings"
 )
 
+var (
+	// ErrInvalidAddress is returned when an address can't be parsed, or a zero netip.Addr or netip.Prefix is used
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrInvalidIPv4Address is returned when parsing a malformed IPv4 address - it's also an ErrInvalidAddress
+	ErrInvalidIPv4Address error = invalidAddressError("invalid IPv4 address")
+	// ErrInvalidIPv6Address is returned when parsing a malformed IPv6 address - it's also an ErrInvalidAddress
+	ErrInvalidIPv6Address error = invalidAddressError("invalid IPv6 address")
+	// ErrMalformedPrefixLength is returned when the prefix length after the '/' isn't a decimal number
+	ErrMalformedPrefixLength = errors.New("malformed prefix length")
+	// ErrPrefixLengthOutOfRange is returned when the prefix length is longer than the address
+	ErrPrefixLengthOutOfRange = errors.New("prefix length out of range")
+	// ErrNilAddress is returned when parsing a nil net.IP or net.IPNet
+	ErrNilAddress = errors.New("nil address")
+	// ErrAddressFamily is returned when an IPv6 address is used with an IPv4 tree, or the other way around
+	ErrAddressFamily = errors.New("wrong address family")
+	// ErrInvalidLength is returned when an address is longer than 32 bits for IPv4, or 128 bits for IPv6
+	ErrInvalidLength = errors.New("invalid address length")
+	// ErrHostBitsSet is returned when an address has bits set beyond its length
+	ErrHostBitsSet = errors.New("bits set beyond the address length")
+)
+
+// invalidAddressError is an invalid address error for one address family, which wraps ErrInvalidAddress
+type invalidAddressError string
+
+func (e invalidAddressError) Error() string {
+	return string(e)
+}
+
+func (e invalidAddressError) Unwrap() error {
+	return ErrInvalidAddress
+}
+
 // ParseIPFromString parses a string address, returning a v4 or v6 IP address
+// - see ParsePrefix for an allocation-free alternative
 // TODO: make this more performant:
 //   - is the fmt.Sprintf necessary?
 func ParseIPFromString(address string) (*IPv4Address, *IPv6Address, error) {
@@ -20,12 +54,18 @@ func ParseIPFromString(address string) (*IPv4Address, *IPv6Address, error) {
 	if len(parts) == 2 {
 		c, err := strconv.ParseUint(parts[1], 10, 8)
 		if err != nil {
-			return nil, nil, fmt.Errorf("couldn't parse CIDR to int: %s", err)
+			return nil, nil, fmt.Errorf("%w: %s", ErrMalformedPrefixLength, err)
 		}
 		if c > 128 {
-			return nil, nil, fmt.Errorf("Invalid CIDR: %d", c)
+			return nil, nil, fmt.Errorf("%w: %d", ErrPrefixLengthOutOfRange, c)
 		}
 		cidr = int(c)
+		if cidr > 32 {
+			if _, err := ParseIPv4(parts[0]); err == nil {
+				// an IPv4 address, with a length only an IPv6 address could have
+				return nil, nil, fmt.Errorf("%w: %d", ErrPrefixLengthOutOfRange, c)
+			}
+		}
 	}
 
 	// try parsing as IPv4 - force CIDR at the end
@@ -58,13 +98,13 @@ func ParseIPFromString(address string) (*IPv4Address, *IPv6Address, error) {
 		}
 	}
 
-	return nil, nil, fmt.Errorf("couldn't parse either v4 or v6 address")
+	return nil, nil, fmt.Errorf("%w: couldn't parse either v4 or v6 address: %s", ErrInvalidAddress, address)
 }
 
 // ParseFromIP builds an IPv4Address or IPv6Address from a net.IP
 func ParseFromIP(ip *net.IP) (*IPv4Address, *IPv6Address, error) {
 	if ip == nil {
-		return nil, nil, fmt.Errorf("Nil address: %v", ip)
+		return nil, nil, ErrNilAddress
 	}
 
 	if v4Addr := ip.To4(); v4Addr != nil {
@@ -76,13 +116,13 @@ func ParseFromIP(ip *net.IP) (*IPv4Address, *IPv6Address, error) {
 		return nil, &ret, nil
 	}
 
-	return nil, nil, fmt.Errorf("couldn't parse either v4 or v6 address: %v", ip)
+	return nil, nil, fmt.Errorf("%w: couldn't parse either v4 or v6 address: %v", ErrInvalidAddress, ip)
 }
 
 // ParseFromIPAddr builds an IPv4Address or IPv6Address from a net.IPNet
 func ParseFromIPAddr(ipNet *net.IPNet) (*IPv4Address, *IPv6Address, error) {
 	if ipNet == nil {
-		return nil, nil, fmt.Errorf("Nil address: %v", ipNet)
+		return nil, nil, ErrNilAddress
 	}
 
 	if v4Addr := ipNet.IP.To4(); v4Addr != nil {
@@ -96,17 +136,14 @@ func ParseFromIPAddr(ipNet *net.IPNet) (*IPv4Address, *IPv6Address, error) {
 		return nil, &ret, nil
 	}
 
-	return nil, nil, fmt.Errorf("couldn't parse either v4 or v6 address: %v", ipNet)
+	return nil, nil, fmt.Errorf("%w: couldn't parse either v4 or v6 address: %v", ErrInvalidAddress, ipNet)
 }
 
 // ParseFromNetIPAddr Builds an IPv4Address or IPv6Address from a netip.Addr
+// - the unspecified addresses, 0.0.0.0 and ::, are accepted
 func ParseFromNetIPAddr(addr netip.Addr) (*IPv4Address, *IPv6Address, error) {
 	if !addr.IsValid() {
-		return nil, nil, fmt.Errorf("address is zero")
-	}
-
-	if addr.IsUnspecified() {
-		return nil, nil, fmt.Errorf("address is unspecified %v", addr.String())
+		return nil, nil, fmt.Errorf("%w: address is zero", ErrInvalidAddress)
 	}
 
 	if addr.Is4() {
@@ -119,13 +156,13 @@ func ParseFromNetIPAddr(addr netip.Addr) (*IPv4Address, *IPv6Address, error) {
 		return nil, &ret, nil
 	}
 
-	return nil, nil, fmt.Errorf("couldn't parse either v4 or v6 address: %v", addr)
+	return nil, nil, fmt.Errorf("%w: couldn't parse either v4 or v6 address: %v", ErrInvalidAddress, addr)
 }
 
 // ParseFromNetIPPrefix Builds an IPv4Address or IPv6Address from a netip.Prefix
 func ParseFromNetIPPrefix(prefix netip.Prefix) (*IPv4Address, *IPv6Address, error) {
 	if !prefix.IsValid() {
-		return nil, nil, fmt.Errorf("address is zero")
+		return nil, nil, fmt.Errorf("%w: prefix is zero", ErrInvalidAddress)
 	}
 
 	addr := prefix.Addr()
@@ -140,5 +177,5 @@ func ParseFromNetIPPrefix(prefix netip.Prefix) (*IPv4Address, *IPv6Address, erro
 		return nil, &ret, nil
 	}
 
-	return nil, nil, fmt.Errorf("couldn't parse either v4 or v6 prefix: %v", prefix)
+	return nil, nil, fmt.Errorf("%w: couldn't parse either v4 or v6 prefix: %v", ErrInvalidAddress, prefix)
 }
@@ -192,7 +192,44 @@ func TestParseIPFromNetIP(t *testing.T) {
 	_, _, err = ParseFromNetIPPrefix(netip.Prefix{})
 	assert.Error(t, err)
 
-	_, _, err = ParseFromNetIPAddr(netip.MustParseAddr("0.0.0.0"))
-	assert.Error(t, err)
+	v4IP, v6IP, err = ParseFromNetIPAddr(netip.MustParseAddr("0.0.0.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, &IPv4Address{Address: 0, Length: 32}, v4IP)
+	assert.Nil(t, v6IP)
+
+	v4IP, v6IP, err = ParseFromNetIPAddr(netip.MustParseAddr("::"))
+	assert.NoError(t, err)
+	assert.Nil(t, v4IP)
+	assert.Equal(t, &IPv6Address{Left: 0, Right: 0, Length: 128}, v6IP)
 
 }
+
+func TestParseErrors(t *testing.T) {
+	_, _, err := ParseIPFromString("10.0.0.0/abc")
+	assert.ErrorIs(t, err, ErrMalformedPrefixLength)
+	_, _, err = ParseIPFromString("10.0.0.0/200")
+	assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange)
+	_, _, err = ParseIPFromString("10.0.0.0/33")
+	assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange)
+	_, _, err = ParseIPFromString("2001:db8::/129")
+	assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange)
+	_, v6IP, err := ParseIPFromString("::ffff:10.0.0.0/104")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(104), v6IP.Length)
+	_, _, err = ParseIPFromString("10.0.0/33")
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+	_, _, err = ParseIPFromString("hello")
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+
+	_, _, err = ParseFromIP(nil)
+	assert.ErrorIs(t, err, ErrNilAddress)
+	_, _, err = ParseFromIP(&net.IP{1, 2, 3})
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+	_, _, err = ParseFromIPAddr(nil)
+	assert.ErrorIs(t, err, ErrNilAddress)
+
+	_, _, err = ParseFromNetIPAddr(netip.Addr{})
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+	_, _, err = ParseFromNetIPPrefix(netip.Prefix{})
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+}
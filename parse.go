@@ -0,0 +1,264 @@
+package patricia
+
+import (
+	"encoding/binary"
+)
+
+// parseInput is the type of text the parsers accept, so that byte slices don't need to be copied to strings
+type parseInput interface {
+	~string | ~[]byte
+}
+
+// ParseIPv4 parses an IPv4 address, with an optional "/length" suffix, without allocating
+// - the length defaults to 32
+// - bits beyond the length are cleared
+func ParseIPv4(address string) (IPv4Address, error) {
+	return parseIPv4(address)
+}
+
+// ParseIPv4Bytes parses an IPv4 address in the same way as ParseIPv4, without converting the input to a string
+func ParseIPv4Bytes(address []byte) (IPv4Address, error) {
+	return parseIPv4(address)
+}
+
+// ParseIPv6 parses an IPv6 address, with an optional "/length" suffix, without allocating
+// - the length defaults to 128
+// - bits beyond the length are cleared
+// - IPv4-mapped addresses, like ::ffff:10.0.0.1, are accepted
+func ParseIPv6(address string) (IPv6Address, error) {
+	return parseIPv6(address)
+}
+
+// ParseIPv6Bytes parses an IPv6 address in the same way as ParseIPv6, without converting the input to a string
+func ParseIPv6Bytes(address []byte) (IPv6Address, error) {
+	return parseIPv6(address)
+}
+
+// ParsePrefix parses an IPv4 or IPv6 address, with an optional "/length" suffix, without allocating
+// - addresses with a ':' are parsed as IPv6, and everything else as IPv4
+// - returns whether the address is IPv4 - only the matching address is set
+func ParsePrefix(address string) (IPv4Address, IPv6Address, bool, error) {
+	return parsePrefix(address)
+}
+
+// ParsePrefixBytes parses an address in the same way as ParsePrefix, without converting the input to a string
+func ParsePrefixBytes(address []byte) (IPv4Address, IPv6Address, bool, error) {
+	return parsePrefix(address)
+}
+
+func parsePrefix[S parseInput](address S) (IPv4Address, IPv6Address, bool, error) {
+	for i := 0; i < len(address); i++ {
+		if address[i] == ':' {
+			v6, err := parseIPv6(address)
+			return IPv4Address{}, v6, false, err
+		}
+	}
+	v4, err := parseIPv4(address)
+	return v4, IPv6Address{}, true, err
+}
+
+func parseIPv4[S parseInput](address S) (IPv4Address, error) {
+	addressEnd := prefixLengthStart(address)
+	bits, ok := parseIPv4Bits(address[:addressEnd])
+	if !ok {
+		return IPv4Address{}, ErrInvalidIPv4Address
+	}
+	length, err := parsePrefixLength(address[addressEnd:], 32)
+	if err != nil {
+		return IPv4Address{}, err
+	}
+
+	bits, _ = MergePrefixes32(bits, length, 0, 0)
+	return NewIPv4Address(bits, length), nil
+}
+
+func parseIPv6[S parseInput](address S) (IPv6Address, error) {
+	addressEnd := prefixLengthStart(address)
+	left, right, ok := parseIPv6Bits(address[:addressEnd])
+	if !ok {
+		return IPv6Address{}, ErrInvalidIPv6Address
+	}
+	length, err := parsePrefixLength(address[addressEnd:], 128)
+	if err != nil {
+		return IPv6Address{}, err
+	}
+
+	left, right, _ = MergePrefixes64(left, right, length, 0, 0, 0)
+	return IPv6Address{Left: left, Right: right, Length: length}, nil
+}
+
+// prefixLengthStart returns the index of the '/' before the prefix length, or the length of the input if there isn't one
+func prefixLengthStart[S parseInput](address S) int {
+	for i := 0; i < len(address); i++ {
+		if address[i] == '/' {
+			return i
+		}
+	}
+	return len(address)
+}
+
+// parsePrefixLength parses the input "/length" suffix, which defaults to maxLength if empty
+func parsePrefixLength[S parseInput](suffix S, maxLength uint) (uint, error) {
+	if len(suffix) == 0 {
+		return maxLength, nil
+	}
+
+	digits := suffix[1:]
+	if len(digits) == 0 || (len(digits) > 1 && digits[0] == '0') {
+		return 0, ErrMalformedPrefixLength
+	}
+	var length uint
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if c < '0' || c > '9' {
+			return 0, ErrMalformedPrefixLength
+		}
+		if length <= maxLength {
+			// no need to keep track once it's too long - this keeps it from overflowing
+			length = length*10 + uint(c-'0')
+		}
+	}
+	if length > maxLength {
+		return 0, ErrPrefixLengthOutOfRange
+	}
+	return length, nil
+}
+
+// parseIPv4Bits parses a dotted-decimal IPv4 address, rejecting octets with leading zeros
+func parseIPv4Bits[S parseInput](address S) (uint32, bool) {
+	var bits uint32
+	var octet, digits, octets int
+	for i := 0; i <= len(address); i++ {
+		if i == len(address) || address[i] == '.' {
+			if digits == 0 || octets == 4 {
+				return 0, false
+			}
+			bits = bits<<8 | uint32(octet)
+			octets++
+			octet, digits = 0, 0
+			continue
+		}
+
+		c := address[i]
+		if c < '0' || c > '9' || (digits > 0 && octet == 0) {
+			return 0, false
+		}
+		octet = octet*10 + int(c-'0')
+		digits++
+		if octet > 255 {
+			return 0, false
+		}
+	}
+	return bits, octets == 4
+}
+
+// parseIPv6Bits parses an IPv6 address in any of the formats in RFC 4291, section 2.2
+// - zones aren't supported
+func parseIPv6Bits[S parseInput](address S) (uint64, uint64, bool) {
+	var ip [16]byte
+	ellipsis := -1 // position of the "::" in ip, if any
+
+	if len(address) >= 2 && address[0] == ':' && address[1] == ':' {
+		ellipsis = 0
+		address = address[2:]
+		if len(address) == 0 {
+			return 0, 0, true
+		}
+	}
+
+	i := 0
+	for i < 16 {
+		// a group of up to 4 hex digits
+		var group uint32
+		digits := 0
+		for ; digits < len(address); digits++ {
+			value, ok := hexValue(address[digits])
+			if !ok {
+				break
+			}
+			if digits == 4 {
+				return 0, 0, false
+			}
+			group = group<<4 | value
+		}
+		if digits == 0 {
+			return 0, 0, false
+		}
+
+		if digits < len(address) && address[digits] == '.' {
+			// the last 4 bytes may be in IPv4 format
+			if (ellipsis < 0 && i != 12) || i > 12 {
+				return 0, 0, false
+			}
+			v4, ok := parseIPv4Bits(address)
+			if !ok {
+				return 0, 0, false
+			}
+			binary.BigEndian.PutUint32(ip[i:], v4)
+			address = address[len(address):]
+			i += 4
+			break
+		}
+
+		ip[i] = byte(group >> 8)
+		ip[i+1] = byte(group)
+		i += 2
+
+		address = address[digits:]
+		if len(address) == 0 {
+			break
+		}
+
+		// groups must be followed by a colon and another group, or a "::"
+		if address[0] != ':' || len(address) == 1 {
+			return 0, 0, false
+		}
+		address = address[1:]
+		if address[0] == ':' {
+			if ellipsis >= 0 {
+				return 0, 0, false
+			}
+			ellipsis = i
+			address = address[1:]
+			if len(address) == 0 {
+				break
+			}
+		}
+	}
+
+	if len(address) != 0 {
+		return 0, 0, false
+	}
+
+	if i < 16 {
+		// expand the "::" into as many zeros as are missing
+		if ellipsis < 0 {
+			return 0, 0, false
+		}
+		zeros := 16 - i
+		for j := i - 1; j >= ellipsis; j-- {
+			ip[j+zeros] = ip[j]
+		}
+		for j := ellipsis; j < ellipsis+zeros; j++ {
+			ip[j] = 0
+		}
+	} else if ellipsis >= 0 {
+		// the "::" has to stand for at least one group
+		return 0, 0, false
+	}
+
+	return binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:]), true
+}
+
+// hexValue returns the value of the input hex digit
+func hexValue(c byte) (uint32, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return uint32(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return uint32(c - 'a' + 10), true
+	case c >= 'A' && c <= 'F':
+		return uint32(c - 'A' + 10), true
+	}
+	return 0, false
+}
@@ -0,0 +1,116 @@
+package patricia
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPv4(t *testing.T) {
+	sut, err := ParseIPv4("1.2.3.4")
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPv4Address(0x01020304, 32), sut)
+
+	sut, err = ParseIPv4("10.11.12.13/8")
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPv4Address(0x0a000000, 8), sut)
+
+	sut, err = ParseIPv4("0.0.0.0/0")
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPv4Address(0, 0), sut)
+
+	sut, err = ParseIPv4Bytes([]byte("255.255.255.255/31"))
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPv4Address(0xfffffffe, 31), sut)
+
+	for _, address := range []string{"", "1.2.3", "1.2.3.4.5", "1.2.3.256", "1.2.3.04", "1..2.3", "1.2.3.4.", "a.b.c.d", "1.2.3.4 ", "::1"} {
+		_, err = ParseIPv4(address)
+		assert.ErrorIs(t, err, ErrInvalidIPv4Address, address)
+		assert.ErrorIs(t, err, ErrInvalidAddress, address)
+	}
+	for _, address := range []string{"1.2.3.4/", "1.2.3.4/a", "1.2.3.4/08", "1.2.3.4/-1", "1.2.3.4/8/8"} {
+		_, err = ParseIPv4(address)
+		assert.ErrorIs(t, err, ErrMalformedPrefixLength, address)
+	}
+	for _, address := range []string{"1.2.3.4/33", "1.2.3.4/999", "1.2.3.4/99999999999999999999999"} {
+		_, err = ParseIPv4(address)
+		assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange, address)
+	}
+}
+
+func TestParseIPv6(t *testing.T) {
+	sut, err := ParseIPv6("2001:0db8:85a3:0000:0000:8a2e:0370:7334")
+	assert.NoError(t, err)
+	assert.Equal(t, IPv6Address{Left: 0x20010db885a30000, Right: 0x00008a2e03707334, Length: 128}, sut)
+
+	sut, err = ParseIPv6("2001:0db8:85a3::8a2e:0370:7334/16")
+	assert.NoError(t, err)
+	assert.Equal(t, IPv6Address{Left: 0x2001000000000000, Right: 0, Length: 16}, sut)
+
+	sut, err = ParseIPv6Bytes([]byte("::ffff:10.10.10.10/120"))
+	assert.NoError(t, err)
+	assert.Equal(t, IPv6Address{Left: 0, Right: 0x0000ffff0a0a0a00, Length: 120}, sut)
+
+	for _, address := range []string{"", ":", ":::", "1:2", "1:2:3:4:5:6:7:8:9", "1::2::3", "12345::", "1:2:3:4:5:6:7:8::", "::1:", "g::",
+		"1:2:3:4:5:6:7::1.2.3.4", "::1.2.3", "fe80::1%eth0", "1.2.3.4"} {
+		_, err = ParseIPv6(address)
+		assert.ErrorIs(t, err, ErrInvalidIPv6Address, address)
+		assert.ErrorIs(t, err, ErrInvalidAddress, address)
+	}
+	_, err = ParseIPv6("::/")
+	assert.ErrorIs(t, err, ErrMalformedPrefixLength)
+	_, err = ParseIPv6("::/129")
+	assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange)
+}
+
+func TestParseMatchesNetIP(t *testing.T) {
+	for _, address := range []string{
+		"::", "::1", "1::", "1:2:3:4:5:6:7:8", "1::8", "1:2:3:4:5:6::8", "1:2:3:4:5:6:1.2.3.4", "::1.2.3.4",
+		"::ffff:1.2.3.4", "ABCD:ef01::", "2001:db8:0:0:1:0:0:1", "fe80::1:2:3:4",
+	} {
+		expected := netip.MustParseAddr(address).As16()
+		sut, err := ParseIPv6(address)
+		assert.NoError(t, err, address)
+		assert.Equal(t, NewIPv6Address(expected[:], 128), sut, address)
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	v4, _, isV4, err := ParsePrefix("10.0.0.1/8")
+	assert.NoError(t, err)
+	assert.True(t, isV4)
+	assert.Equal(t, NewIPv4Address(0x0a000000, 8), v4)
+
+	_, v6, isV4, err := ParsePrefixBytes([]byte("2001:db8::/32"))
+	assert.NoError(t, err)
+	assert.False(t, isV4)
+	assert.Equal(t, IPv6Address{Left: 0x20010db800000000, Length: 32}, v6)
+
+	_, _, _, err = ParsePrefix("10.0.0.1/33")
+	assert.ErrorIs(t, err, ErrPrefixLengthOutOfRange)
+	_, _, _, err = ParsePrefix("2001:db8::/")
+	assert.ErrorIs(t, err, ErrMalformedPrefixLength)
+	_, _, _, err = ParsePrefix("hello")
+	assert.ErrorIs(t, err, ErrInvalidIPv4Address)
+
+	v4Bytes := []byte("10.0.0.1/8")
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		ParsePrefix("10.0.0.1/8")
+		ParsePrefix("2001:db8::ffff:1.2.3.4/32")
+		ParsePrefixBytes(v4Bytes)
+		ParsePrefix("10.0.0.1/99")
+	}))
+}
+
+func BenchmarkParsePrefix(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ParsePrefix("2001:db8:85a3::8a2e:370:7334/64")
+	}
+}
+
+func BenchmarkParseIPFromString(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ParseIPFromString("2001:db8:85a3::8a2e:370:7334/64")
+	}
+}
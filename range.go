@@ -0,0 +1,108 @@
+package patricia
+
+import (
+	"math/bits"
+)
+
+// RangeToPrefixesV4 returns the minimal set of prefixes that exactly covers the addresses from start to end, inclusive
+// - the lengths of start and end are ignored
+// - prefixes are returned in ascending address order
+// - returns nil if start is greater than end
+func RangeToPrefixesV4(start IPv4Address, end IPv4Address) []IPv4Address {
+	return AppendRangeToPrefixesV4(nil, start, end)
+}
+
+// AppendRangeToPrefixesV4 appends the minimal set of prefixes covering start to end, inclusive, to ret
+func AppendRangeToPrefixesV4(ret []IPv4Address, start IPv4Address, end IPv4Address) []IPv4Address {
+	first := start.Address
+	last := end.Address
+	if first > last {
+		return ret
+	}
+
+	for {
+		// the largest block that's aligned on first, and doesn't run past last
+		hostBits := uint(bits.TrailingZeros32(first))
+		for hostBits > 0 && first|hostMask32(hostBits) > last {
+			hostBits--
+		}
+		ret = append(ret, NewIPv4Address(first, 32-hostBits))
+
+		blockLast := first | hostMask32(hostBits)
+		if blockLast >= last {
+			return ret
+		}
+		first = blockLast + 1
+	}
+}
+
+// RangeToPrefixesV6 returns the minimal set of prefixes that exactly covers the addresses from start to end, inclusive
+// - the lengths of start and end are ignored
+// - prefixes are returned in ascending address order
+// - returns nil if start is greater than end
+func RangeToPrefixesV6(start IPv6Address, end IPv6Address) []IPv6Address {
+	return AppendRangeToPrefixesV6(nil, start, end)
+}
+
+// AppendRangeToPrefixesV6 appends the minimal set of prefixes covering start to end, inclusive, to ret
+func AppendRangeToPrefixesV6(ret []IPv6Address, start IPv6Address, end IPv6Address) []IPv6Address {
+	firstLeft, firstRight := start.Left, start.Right
+	lastLeft, lastRight := end.Left, end.Right
+	if compare128(firstLeft, firstRight, lastLeft, lastRight) > 0 {
+		return ret
+	}
+
+	for {
+		// the largest block that's aligned on first, and doesn't run past last
+		hostBits := uint(bits.TrailingZeros64(firstRight))
+		if firstRight == 0 {
+			hostBits = 64 + uint(bits.TrailingZeros64(firstLeft))
+		}
+		for hostBits > 0 {
+			maskLeft, maskRight := hostMask128(hostBits)
+			if compare128(firstLeft|maskLeft, firstRight|maskRight, lastLeft, lastRight) <= 0 {
+				break
+			}
+			hostBits--
+		}
+		ret = append(ret, IPv6Address{Left: firstLeft, Right: firstRight, Length: 128 - hostBits})
+
+		maskLeft, maskRight := hostMask128(hostBits)
+		blockLastLeft, blockLastRight := firstLeft|maskLeft, firstRight|maskRight
+		if compare128(blockLastLeft, blockLastRight, lastLeft, lastRight) >= 0 {
+			return ret
+		}
+
+		var carry uint64
+		firstRight, carry = bits.Add64(blockLastRight, 1, 0)
+		firstLeft = blockLastLeft + carry
+	}
+}
+
+// hostMask32 returns a mask with the rightmost hostBits bits set
+func hostMask32(hostBits uint) uint32 {
+	return ^_leftMasks32[32-hostBits]
+}
+
+// hostMask128 returns a mask with the rightmost hostBits bits set, as two uint64s
+func hostMask128(hostBits uint) (uint64, uint64) {
+	if hostBits <= 64 {
+		return 0, ^_leftMasks64[64-hostBits]
+	}
+	return ^_leftMasks64[128-hostBits], ^uint64(0)
+}
+
+// compare128 compares two 128-bit values, returning -1, 0, or 1
+func compare128(leftA uint64, rightA uint64, leftB uint64, rightB uint64) int {
+	switch {
+	case leftA < leftB:
+		return -1
+	case leftA > leftB:
+		return 1
+	case rightA < rightB:
+		return -1
+	case rightA > rightB:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package patricia
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rangeStrings4(prefixes []IPv4Address) []string {
+	ret := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ret = append(ret, prefix.String())
+	}
+	return ret
+}
+
+func rangeStrings6(prefixes []IPv6Address) []string {
+	ret := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ret = append(ret, prefix.String())
+	}
+	return ret
+}
+
+func TestRangeToPrefixesV4(t *testing.T) {
+	v4 := func(s string) IPv4Address {
+		return NewIPv4AddressFromBytes(net.ParseIP(s), 32)
+	}
+
+	assert.Equal(t, []string{"10.0.0.0/8"}, rangeStrings4(RangeToPrefixesV4(v4("10.0.0.0"), v4("10.255.255.255"))))
+	assert.Equal(t, []string{"10.0.0.1/32"}, rangeStrings4(RangeToPrefixesV4(v4("10.0.0.1"), v4("10.0.0.1"))))
+	assert.Equal(t, []string{"0.0.0.0/0"}, rangeStrings4(RangeToPrefixesV4(v4("0.0.0.0"), v4("255.255.255.255"))))
+	assert.Equal(t, []string{"255.255.255.255/32"}, rangeStrings4(RangeToPrefixesV4(v4("255.255.255.255"), v4("255.255.255.255"))))
+	assert.Equal(t, []string{
+		"10.0.0.1/32",
+		"10.0.0.2/31",
+		"10.0.0.4/30",
+		"10.0.0.8/29",
+		"10.0.0.16/28",
+		"10.0.0.32/27",
+		"10.0.0.64/26",
+		"10.0.0.128/25",
+		"10.0.1.0/24",
+		"10.0.2.0/31",
+	}, rangeStrings4(RangeToPrefixesV4(v4("10.0.0.1"), v4("10.0.2.1"))))
+	assert.Equal(t, []string{
+		"192.168.0.0/23",
+		"192.168.2.0/24",
+	}, rangeStrings4(RangeToPrefixesV4(v4("192.168.0.0"), v4("192.168.2.255"))))
+
+	// lengths are ignored
+	assert.Equal(t, []string{"10.0.0.0/8"}, rangeStrings4(RangeToPrefixesV4(NewIPv4Address(0x0a000000, 8), NewIPv4Address(0x0affffff, 3))))
+
+	// backwards range
+	assert.Nil(t, RangeToPrefixesV4(v4("10.0.0.2"), v4("10.0.0.1")))
+}
+
+func TestRangeToPrefixesV6(t *testing.T) {
+	v6 := func(s string) IPv6Address {
+		return NewIPv6Address(net.ParseIP(s), 128)
+	}
+
+	assert.Equal(t, []string{"2001:db8::/32"}, rangeStrings6(RangeToPrefixesV6(v6("2001:db8::"), v6("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"))))
+	assert.Equal(t, []string{"::/0"}, rangeStrings6(RangeToPrefixesV6(v6("::"), v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))))
+	assert.Equal(t, []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff/128"},
+		rangeStrings6(RangeToPrefixesV6(v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), v6("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))))
+
+	// crossing the 64-bit boundary
+	assert.Equal(t, []string{
+		"2001:db8:0:1:ffff:ffff:ffff:ffff/128",
+		"2001:db8:0:2::/64",
+		"2001:db8:0:3::/127",
+	}, rangeStrings6(RangeToPrefixesV6(v6("2001:db8:0:1:ffff:ffff:ffff:ffff"), v6("2001:db8:0:3::1"))))
+
+	assert.Equal(t, []string{
+		"2001:db8::/63",
+		"2001:db8:0:2::/64",
+	}, rangeStrings6(RangeToPrefixesV6(v6("2001:db8::"), v6("2001:db8:0:2:ffff:ffff:ffff:ffff"))))
+
+	// backwards range
+	assert.Nil(t, RangeToPrefixesV6(v6("2001:db8::2"), v6("2001:db8::1")))
+}
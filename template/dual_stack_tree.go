@@ -0,0 +1,254 @@
+// Template file.
+
+package template
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"net/netip"
+
+	"github.com/kentik/patricia"
+)
+
+// DualStackTree is an IP Address patricia tree that holds both IPv4 and IPv6 addresses
+// - IPv4 addresses go in an IPv4 tree, and IPv6 addresses go in an IPv6 tree
+// - IPv4-mapped IPv6 addresses are IPv6 addresses - use netip.Addr.Unmap() first if that's not what you want
+type DualStackTree struct {
+	v4 *TreeV4
+	v6 *TreeV6
+}
+
+// NewDualStackTree returns a new DualStackTree
+func NewDualStackTree() *DualStackTree {
+	return &DualStackTree{
+		v4: NewTreeV4(),
+		v6: NewTreeV6(),
+	}
+}
+
+// V4 returns the tree holding the IPv4 addresses
+func (t *DualStackTree) V4() *TreeV4 {
+	return t.v4
+}
+
+// V6 returns the tree holding the IPv6 addresses
+func (t *DualStackTree) V6() *TreeV6 {
+	return t.v6
+}
+
+// Clone creates an identical copy of the tree
+// - Note: the items in the tree are not deep copied
+func (t *DualStackTree) Clone() *DualStackTree {
+	return &DualStackTree{
+		v4: t.v4.Clone(),
+		v6: t.v6.Clone(),
+	}
+}
+
+// CountTags returns the number of tags in both trees
+func (t *DualStackTree) CountTags() int {
+	return t.v4.CountTags() + t.v6.CountTags()
+}
+
+// Set the single value for a node - overwrites what's there
+// - returns whether the tag count at this address was increased, and how many tags at this address
+func (t *DualStackTree) Set(prefix netip.Prefix, tag GeneratedType) (bool, int, error) {
+	if prefix.Addr().Is4() {
+		return t.v4.SetPrefix(prefix, tag)
+	}
+	return t.v6.SetPrefix(prefix, tag)
+}
+
+// Add adds a tag to the tree
+// - if matchFunc is non-nil, it will be used to ensure uniqueness at this node
+// - returns whether the tag count at this address was increased, and how many tags at this address
+func (t *DualStackTree) Add(prefix netip.Prefix, tag GeneratedType, matchFunc MatchesFunc) (bool, int, error) {
+	if prefix.Addr().Is4() {
+		return t.v4.AddPrefix(prefix, tag, matchFunc)
+	}
+	return t.v6.AddPrefix(prefix, tag, matchFunc)
+}
+
+// Delete a tag from the tree if it matches matchVal, as determined by matchFunc. Returns how many tags are removed
+func (t *DualStackTree) Delete(prefix netip.Prefix, matchFunc MatchesFunc, matchVal GeneratedType) (int, error) {
+	if prefix.Addr().Is4() {
+		return t.v4.DeletePrefix(prefix, matchFunc, matchVal)
+	}
+	return t.v6.DeletePrefix(prefix, matchFunc, matchVal)
+}
+
+// FindTags finds all matching tags for given address
+// - use FindTagsAppend if you can reuse slices, to cut down on allocations
+func (t *DualStackTree) FindTags(prefix netip.Prefix) ([]GeneratedType, error) {
+	ret := make([]GeneratedType, 0)
+	return t.FindTagsAppend(ret, prefix)
+}
+
+// FindTagsAppend finds all matching tags for given address, appending them to ret
+func (t *DualStackTree) FindTagsAppend(ret []GeneratedType, prefix netip.Prefix) ([]GeneratedType, error) {
+	if prefix.Addr().Is4() {
+		address, err := t.v4.addressFromPrefix(prefix)
+		if err != nil {
+			return ret, err
+		}
+		return t.v4.FindTagsAppend(ret, address), nil
+	}
+
+	address, err := t.v6.addressFromPrefix(prefix)
+	if err != nil {
+		return ret, err
+	}
+	return t.v6.FindTagsAppend(ret, address), nil
+}
+
+// FindDeepestTag finds a tag at the deepest level in the tree, representing the closest match.
+// - if that target node has multiple tags, the first in the list is returned
+func (t *DualStackTree) FindDeepestTag(prefix netip.Prefix) (bool, GeneratedType, error) {
+	var ret GeneratedType
+	if prefix.Addr().Is4() {
+		address, err := t.v4.addressFromPrefix(prefix)
+		if err != nil {
+			return false, ret, err
+		}
+		found, tag := t.v4.FindDeepestTag(address)
+		return found, tag, nil
+	}
+
+	address, err := t.v6.addressFromPrefix(prefix)
+	if err != nil {
+		return false, ret, err
+	}
+	found, tag := t.v6.FindDeepestTag(address)
+	return found, tag, nil
+}
+
+// FindDeepestTags finds all tags at the deepest level in the tree, representing the closest match
+// - use FindDeepestTagsAppend if you can reuse slices, to cut down on allocations
+func (t *DualStackTree) FindDeepestTags(prefix netip.Prefix) (bool, []GeneratedType, error) {
+	ret := make([]GeneratedType, 0)
+	return t.FindDeepestTagsAppend(ret, prefix)
+}
+
+// FindDeepestTagsAppend finds all tags at the deepest level in the tree, representing the closest match
+// - appends results to the input slice
+func (t *DualStackTree) FindDeepestTagsAppend(ret []GeneratedType, prefix netip.Prefix) (bool, []GeneratedType, error) {
+	if prefix.Addr().Is4() {
+		address, err := t.v4.addressFromPrefix(prefix)
+		if err != nil {
+			return false, ret, err
+		}
+		found, tags := t.v4.FindDeepestTagsAppend(ret, address)
+		return found, tags, nil
+	}
+
+	address, err := t.v6.addressFromPrefix(prefix)
+	if err != nil {
+		return false, ret, err
+	}
+	found, tags := t.v6.FindDeepestTagsAppend(ret, address)
+	return found, tags, nil
+}
+
+// LoadTSV adds the tags from r to the tree, returning how many were added
+// - lines are read as in TreeV4.LoadTSV, and may have IPv4 or IPv6 prefixes
+func (t *DualStackTree) LoadTSV(r io.Reader) (int, error) {
+	return scanTSV(r, func(prefix string, text string) error {
+		v4, v6, isV4, err := patricia.ParsePrefix(prefix)
+		if err != nil {
+			return fmt.Errorf("prefix %q: %w", prefix, err)
+		}
+		var tag GeneratedType
+		if err := parseTagText(text, &tag); err != nil {
+			return fmt.Errorf("tag %q: %w", text, err)
+		}
+		if isV4 {
+			t.v4.Add(v4, tag, nil)
+		} else {
+			t.v6.Add(v6, tag, nil)
+		}
+		return nil
+	})
+}
+
+// WriteTSV writes a line to w for each tag in the tree, in the order described in Iterate
+// - lines are written as in TreeV4.WriteTSV
+func (t *DualStackTree) WriteTSV(w io.Writer) error {
+	if err := t.v4.WriteTSV(w); err != nil {
+		return err
+	}
+	return t.v6.WriteTSV(w)
+}
+
+// DualStackTreeIterator is a stateful iterator over a dual stack tree
+type DualStackTreeIterator struct {
+	v4        *TreeIteratorV4
+	v6        *TreeIteratorV6
+	v6Started bool
+}
+
+// Iterate returns an iterator to find all nodes from both trees: all IPv4 addresses
+// first, then all IPv6 addresses, each in the order described in TreeV4.IterateFrom.
+// It is important for the tree to not be modified while using the iterator.
+func (t *DualStackTree) Iterate() *DualStackTreeIterator {
+	return &DualStackTreeIterator{
+		v4: t.v4.Iterate(),
+		v6: t.v6.Iterate(),
+	}
+}
+
+// All returns a range-over-func iterator over every prefix in the tree with tags,
+// along with its tags, in the order described in Iterate. The tags slice is a copy,
+// so it's safe to keep around.
+func (t *DualStackTree) All() iter.Seq2[netip.Prefix, []GeneratedType] {
+	return func(yield func(netip.Prefix, []GeneratedType) bool) {
+		treeIter := t.Iterate()
+		for treeIter.Next() {
+			if !yield(treeIter.Prefix(), treeIter.Tags()) {
+				return
+			}
+		}
+	}
+}
+
+// Reset rewinds the iterator to the beginning of its iteration, so that it
+// can be reused without allocating.
+func (iter *DualStackTreeIterator) Reset() {
+	iter.v4.Reset()
+	iter.v6.Reset()
+	iter.v6Started = false
+}
+
+// Next jumps to the next element of the tree. It returns false if there
+// is none.
+func (iter *DualStackTreeIterator) Next() bool {
+	if !iter.v6Started {
+		if iter.v4.Next() {
+			return true
+		}
+		iter.v6Started = true
+	}
+	return iter.v6.Next()
+}
+
+// Prefix returns the prefix of the current node
+func (iter *DualStackTreeIterator) Prefix() netip.Prefix {
+	if iter.v6Started {
+		return iter.v6.Prefix()
+	}
+	return iter.v4.Prefix()
+}
+
+// Tags returns the current tags for the iterator
+func (iter *DualStackTreeIterator) Tags() []GeneratedType {
+	return iter.TagsWithBuffer(nil)
+}
+
+// TagsWithBuffer returns the current tags for the iterator. To avoid allocation,
+// it uses the provided buffer.
+func (iter *DualStackTreeIterator) TagsWithBuffer(ret []GeneratedType) []GeneratedType {
+	if iter.v6Started {
+		return iter.v6.TagsWithBuffer(ret)
+	}
+	return iter.v4.TagsWithBuffer(ret)
+}
@@ -0,0 +1,373 @@
+// Template file.
+
+package template
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"unsafe"
+
+	"github.com/kentik/patricia"
+)
+
+// FrozenTreeV4 is a read-only IP Address patricia tree, served directly from the frozen layout written by TreeV4.Freeze
+// - the data isn't copied or deserialized, so it can be a file mapped into memory with patricia.MapFile
+// - a mapped file is shared by every process using it, and lives outside the Go heap
+// - tags are decoded on each lookup, without allocating unless they're interfaces, so string tags point into the data
+type FrozenTreeV4 struct {
+	nodes []byte
+	tags  []byte
+}
+
+// Freeze returns the tree in the frozen layout, to be written to a file and loaded with NewFrozenTreeV4
+// - unused nodes are dropped, and the rest are numbered in iteration order, so children always come after their parent
+// - returns patricia.ErrUnsupportedTagType if a tag isn't a bool, string, or fixed-size number
+func (t *TreeV4) Freeze() ([]byte, error) {
+	// number the used nodes in pre-order - index 0 stays unused, and the root stays at 1
+	newIndexes := make([]uint32, len(t.nodes))
+	order := make([]uint, 1, len(t.nodes))
+	stack := []uint{1}
+	for len(stack) > 0 {
+		nodeIndex := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		newIndexes[nodeIndex] = uint32(len(order))
+		order = append(order, nodeIndex)
+
+		node := &t.nodes[nodeIndex]
+		if node.Right != 0 {
+			stack = append(stack, node.Right)
+		}
+		if node.Left != 0 {
+			stack = append(stack, node.Left)
+		}
+	}
+
+	// the header is filled in at the end, and node 0 is unused
+	var tags []byte
+	var err error
+	buf := make([]byte, frozenHeaderSize+treeNodeV4FrozenSize, frozenHeaderSize+len(order)*treeNodeV4FrozenSize+binaryChecksumSize)
+	for _, nodeIndex := range order[1:] {
+		node := t.nodes[nodeIndex]
+		node.Left = uint(newIndexes[node.Left])
+		node.Right = uint(newIndexes[node.Right])
+		buf = node.appendBinary(buf)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(tags)))
+
+		key := uint64(nodeIndex) << 32
+		for i := 0; i < node.TagCount; i++ {
+			tags, err = appendTagBinary(tags, t.tags[key+uint64(i)])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	buf = append(buf, tags...)
+
+	copy(buf, frozenMagic)
+	binary.BigEndian.PutUint16(buf[4:], frozenVersion)
+	buf[6] = byte(t.addressBits())
+	buf[7] = t.tagKind()
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(order)))
+	binary.BigEndian.PutUint64(buf[12:], uint64(len(tags)))
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// NewFrozenTreeV4 returns a read-only tree serving lookups from data written by TreeV4.Freeze
+// - data is used in place, so it mustn't be modified or unmapped while the tree, or any string tag from it, is in use
+// - the whole of data is checksummed, and its nodes and the kinds and sizes of its tags are checked, so lookups can't fail
+// - returns patricia.ErrInvalidBinary, patricia.ErrUnsupportedVersion or patricia.ErrChecksumMismatch if the data is malformed
+// - returns patricia.ErrIncompatibleBinary if the data is from a tree of another address family or tag type
+func NewFrozenTreeV4(data []byte) (*FrozenTreeV4, error) {
+	t := &FrozenTreeV4{}
+	if len(data) < frozenHeaderSize+binaryChecksumSize || string(data[:len(frozenMagic)]) != frozenMagic {
+		return nil, patricia.ErrInvalidBinary
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version != frozenVersion {
+		return nil, fmt.Errorf("%w: %d", patricia.ErrUnsupportedVersion, version)
+	}
+	checksumStart := len(data) - binaryChecksumSize
+	if crc32.ChecksumIEEE(data[:checksumStart]) != binary.BigEndian.Uint32(data[checksumStart:]) {
+		return nil, patricia.ErrChecksumMismatch
+	}
+	if uint(data[6]) != t.addressBits() {
+		return nil, fmt.Errorf("%w: %d-bit addresses", patricia.ErrIncompatibleBinary, data[6])
+	}
+	kind := t.tagKind()
+	if kind != 0 && data[7] != kind {
+		return nil, fmt.Errorf("%w: tags of kind %d", patricia.ErrIncompatibleBinary, data[7])
+	}
+
+	nodeCount := uint64(binary.BigEndian.Uint32(data[8:]))
+	tagsSize := binary.BigEndian.Uint64(data[12:])
+	buf := data[frozenHeaderSize:checksumStart]
+	if nodeCount < 2 || uint64(len(buf)) != nodeCount*treeNodeV4FrozenSize+tagsSize {
+		return nil, patricia.ErrInvalidBinary
+	}
+	t.nodes = buf[:nodeCount*treeNodeV4FrozenSize]
+	t.tags = buf[nodeCount*treeNodeV4FrozenSize:]
+
+	// children must come after their parent, so lookups can't loop, and each node below the root must have one parent,
+	// so the length of the path down to it is known, and must fit in an address
+	pathLengths := make([]uint8, nodeCount)
+	hasParent := make([]bool, nodeCount)
+	var nextTagOffset uint64
+	for i := uint(1); i < uint(nodeCount); i++ {
+		node, tagOffset := t.node(i)
+		if (node.Left != 0 && node.Left <= i) || (node.Right != 0 && node.Right <= i) || uint64(node.Left) >= nodeCount ||
+			uint64(node.Right) >= nodeCount || (i == 1 && node.prefixLength != 0) || (i != 1 && !hasParent[i]) ||
+			tagOffset != nextTagOffset || uint64(node.TagCount) > (tagsSize-tagOffset)/2 { // every tag is at least 2 bytes
+			return nil, fmt.Errorf("%w: node %d is malformed", patricia.ErrInvalidBinary, i)
+		}
+		for _, child := range [2]uint{node.Left, node.Right} {
+			if child == 0 {
+				continue
+			}
+			childNode, _ := t.node(child)
+			pathLength := uint(pathLengths[i]) + childNode.prefixLength
+			if hasParent[child] || pathLength > t.addressBits() {
+				return nil, fmt.Errorf("%w: node %d is malformed", patricia.ErrInvalidBinary, child)
+			}
+			pathLengths[child] = uint8(pathLength)
+			hasParent[child] = true
+		}
+
+		// each node's tags must follow those of the node before it, so they're all checked here, and lookups can't
+		// find any that don't decode
+		buf = t.tags[tagOffset:]
+		for j := 0; j < node.TagCount; j++ {
+			tagKind, size, err := tagBinarySize(buf)
+			if err != nil {
+				return nil, fmt.Errorf("node %d: %w", i, err)
+			}
+			if kind != 0 && tagKind != kind {
+				return nil, fmt.Errorf("%w: tags of kind %d", patricia.ErrIncompatibleBinary, tagKind)
+			}
+			if kind == 0 {
+				// interfaces can hold tags of any kind, but other types can't be checked by kind
+				if value, _, _ := readTagBinary(buf); !t.canHold(value) {
+					return nil, fmt.Errorf("%w: %T tags", patricia.ErrIncompatibleBinary, value)
+				}
+			}
+			buf = buf[size:]
+		}
+		nextTagOffset = tagsSize - uint64(len(buf))
+	}
+	if nextTagOffset != tagsSize {
+		return nil, fmt.Errorf("%w: %d trailing bytes of tags", patricia.ErrInvalidBinary, tagsSize-nextTagOffset)
+	}
+	return t, nil
+}
+
+// tagKind returns the kind of tags in the frozen layout of the tree, or 0 if they can be of any kind
+func (t *FrozenTreeV4) tagKind() byte {
+	var tag GeneratedType
+	return tagKindOf(&tag)
+}
+
+// canHold returns whether the input value can be a tag of the tree
+func (t *FrozenTreeV4) canHold(value any) bool {
+	_, ok := value.(GeneratedType)
+	return ok
+}
+
+// node returns the node at the input index, and the offset of its tags
+func (t *FrozenTreeV4) node(nodeIndex uint) (treeNodeV4, uint64) {
+	var node treeNodeV4
+	buf := node.readBinary(t.nodes[nodeIndex*treeNodeV4FrozenSize:])
+	return node, binary.BigEndian.Uint64(buf)
+}
+
+// appendTags appends the tags of the input node to ret
+func (t *FrozenTreeV4) appendTags(ret []GeneratedType, node *treeNodeV4, tagOffset uint64) []GeneratedType {
+	var tag GeneratedType
+	for i := 0; i < node.TagCount; i++ {
+		tagOffset = t.readTag(tagOffset, &tag)
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+// readTag decodes the tag at the input offset into tag, returning the offset of the next one
+// - the tags were checked in NewFrozenTreeV4, so they're decoded straight from the data, without allocating
+// - interface tags are the exception, as they have to be boxed
+func (t *FrozenTreeV4) readTag(tagOffset uint64, tag *GeneratedType) uint64 {
+	value := t.tags[tagOffset+1:]
+	switch p := any(tag).(type) {
+	case *bool:
+		*p = value[0] == 1
+		return tagOffset + 2
+	case *int:
+		*p = int(binary.BigEndian.Uint64(value))
+		return tagOffset + 9
+	case *int8:
+		*p = int8(value[0])
+		return tagOffset + 2
+	case *int16:
+		*p = int16(binary.BigEndian.Uint16(value))
+		return tagOffset + 3
+	case *int32:
+		*p = int32(binary.BigEndian.Uint32(value))
+		return tagOffset + 5
+	case *int64:
+		*p = int64(binary.BigEndian.Uint64(value))
+		return tagOffset + 9
+	case *uint:
+		*p = uint(binary.BigEndian.Uint64(value))
+		return tagOffset + 9
+	case *uint8:
+		*p = value[0]
+		return tagOffset + 2
+	case *uint16:
+		*p = binary.BigEndian.Uint16(value)
+		return tagOffset + 3
+	case *uint32:
+		*p = binary.BigEndian.Uint32(value)
+		return tagOffset + 5
+	case *uint64:
+		*p = binary.BigEndian.Uint64(value)
+		return tagOffset + 9
+	case *float32:
+		*p = math.Float32frombits(binary.BigEndian.Uint32(value))
+		return tagOffset + 5
+	case *float64:
+		*p = math.Float64frombits(binary.BigEndian.Uint64(value))
+		return tagOffset + 9
+	case *complex64:
+		*p = complex(math.Float32frombits(binary.BigEndian.Uint32(value)), math.Float32frombits(binary.BigEndian.Uint32(value[4:])))
+		return tagOffset + 9
+	case *complex128:
+		*p = complex(math.Float64frombits(binary.BigEndian.Uint64(value)), math.Float64frombits(binary.BigEndian.Uint64(value[8:])))
+		return tagOffset + 17
+	case *string:
+		length := uint64(binary.BigEndian.Uint32(value))
+		*p = ""
+		if length > 0 {
+			*p = unsafe.String(&value[4], length)
+		}
+		return tagOffset + 5 + length
+	}
+
+	decoded, rest, _ := readTagBinary(t.tags[tagOffset:])
+	*tag, _ = decoded.(GeneratedType)
+	return uint64(len(t.tags) - len(rest))
+}
+
+// FindTags finds all matching tags for given address
+// - use FindTagsAppend if you can reuse slices, to cut down on allocations
+func (t *FrozenTreeV4) FindTags(address patricia.IPv4Address) []GeneratedType {
+	ret := make([]GeneratedType, 0)
+	return t.FindTagsAppend(ret, address)
+}
+
+// FindTagsAppend finds all matching tags for given address, appending them to ret
+func (t *FrozenTreeV4) FindTagsAppend(ret []GeneratedType, address patricia.IPv4Address) []GeneratedType {
+	root, tagOffset := t.node(1)
+	if root.TagCount > 0 {
+		ret = t.appendTags(ret, &root, tagOffset)
+	}
+
+	if address.Length == 0 {
+		// caller just looking for root tags
+		return ret
+	}
+
+	var nodeIndex uint
+	if !address.IsLeftBitSet() {
+		nodeIndex = root.Left
+	} else {
+		nodeIndex = root.Right
+	}
+
+	// traverse the tree
+	for nodeIndex != 0 {
+		node, tagOffset := t.node(nodeIndex)
+		matchCount := node.MatchCount(address)
+		if matchCount < node.prefixLength {
+			// didn't match the entire node - we're done
+			return ret
+		}
+
+		// matched the full node - get its tags, then chop off the bits we've already matched and continue
+		if node.TagCount > 0 {
+			ret = t.appendTags(ret, &node, tagOffset)
+		}
+
+		if matchCount == address.Length {
+			// exact match - we're done
+			return ret
+		}
+
+		// there's still more address - keep traversing
+		address.ShiftLeft(matchCount)
+		if !address.IsLeftBitSet() {
+			nodeIndex = node.Left
+		} else {
+			nodeIndex = node.Right
+		}
+	}
+	return ret
+}
+
+// FindDeepestTag finds a tag at the deepest level in the tree, representing the closest match.
+// - if that target node has multiple tags, the first in the list is returned
+func (t *FrozenTreeV4) FindDeepestTag(address patricia.IPv4Address) (bool, GeneratedType) {
+	var found bool
+	var ret GeneratedType
+
+	root, tagOffset := t.node(1)
+	if root.TagCount > 0 {
+		ret = t.firstTag(tagOffset)
+		found = true
+	}
+
+	if address.Length == 0 {
+		// caller just looking for root tags
+		return found, ret
+	}
+
+	var nodeIndex uint
+	if !address.IsLeftBitSet() {
+		nodeIndex = root.Left
+	} else {
+		nodeIndex = root.Right
+	}
+
+	// traverse the tree
+	for nodeIndex != 0 {
+		node, tagOffset := t.node(nodeIndex)
+		matchCount := node.MatchCount(address)
+		if matchCount < node.prefixLength {
+			// didn't match the entire node - we're done
+			return found, ret
+		}
+
+		// matched the full node - get its tags, then chop off the bits we've already matched and continue
+		if node.TagCount > 0 {
+			ret = t.firstTag(tagOffset)
+			found = true
+		}
+
+		if matchCount == address.Length {
+			// exact match - we're done
+			return found, ret
+		}
+
+		// there's still more address - keep traversing
+		address.ShiftLeft(matchCount)
+		if !address.IsLeftBitSet() {
+			nodeIndex = node.Left
+		} else {
+			nodeIndex = node.Right
+		}
+	}
+	return found, ret
+}
+
+// firstTag returns the tag at the input offset
+func (t *FrozenTreeV4) firstTag(tagOffset uint64) GeneratedType {
+	var tag GeneratedType
+	t.readTag(tagOffset, &tag)
+	return tag
+}
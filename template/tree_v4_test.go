@@ -2,8 +2,14 @@ package template
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kentik/patricia"
@@ -410,6 +416,143 @@ func TestFindDeepestTags(t *testing.T) {
 	assert.Equal("root_node", tags[0])
 }
 
+func TestFindDeepestWithPrefix(t *testing.T) {
+	tree := NewTreeV4()
+
+	// nothing in the tree
+	found, prefix, _ := tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{192, 0, 2, 1}, 32))
+	assert.False(t, found)
+	assert.Equal(t, uint(0), prefix.Length)
+
+	tree.Add(ipv4FromBytes([]byte{192, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 0, 2, 0}, 24), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 0, 2, 0}, 24), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 0, 2, 128}, 25), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 0, 2, 200}, 32), "E", nil)
+
+	found, prefix, tag := tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{192, 0, 2, 1}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "B", tag)
+	assert.Equal(t, "192.0.2.0/24", prefix.String())
+	assert.Equal(t, ipv4FromBytes([]byte{192, 0, 2, 0}, 24), prefix)
+
+	found, prefix, tag = tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{192, 0, 2, 201}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "D", tag)
+	assert.Equal(t, "192.0.2.128/25", prefix.String())
+
+	found, prefix, tag = tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{192, 0, 2, 200}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "E", tag)
+	assert.Equal(t, "192.0.2.200/32", prefix.String())
+
+	found, prefix, tag = tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{192, 168, 1, 1}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "A", tag)
+	assert.Equal(t, ipv4FromBytes([]byte{192, 0, 0, 0}, 8), prefix)
+
+	found, prefix, tags := tree.FindDeepestTagsWithPrefix(ipv4FromBytes([]byte{192, 0, 2, 1}, 32))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"B", "C"}, tags)
+	assert.Equal(t, "192.0.2.0/24", prefix.String())
+
+	buf := make([]GeneratedType, 0)
+	found, prefix, tags = tree.FindDeepestTagsWithPrefixAppend(buf, ipv4FromBytes([]byte{192, 0, 2, 130}, 31))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"D"}, tags)
+	assert.Equal(t, "192.0.2.128/25", prefix.String())
+
+	// no match
+	found, _, tags = tree.FindDeepestTagsWithPrefix(ipv4FromBytes([]byte{10, 0, 0, 1}, 32))
+	assert.False(t, found)
+	assert.Equal(t, 0, len(tags))
+
+	// root match
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	found, prefix, tag = tree.FindDeepestTagWithPrefix(ipv4FromBytes([]byte{10, 0, 0, 1}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "ROOT", tag)
+	assert.Equal(t, "0.0.0.0/0", prefix.String())
+}
+
+func TestGetExact(t *testing.T) {
+	tree := NewTreeV4()
+
+	found, tags := tree.GetExact(patricia.IPv4Address{})
+	assert.False(t, found)
+	assert.Equal(t, 0, len(tags))
+
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B1", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B2", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 0}, 24), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 128, 0}, 24), "D", nil)
+
+	found, tags = tree.GetExact(ipv4FromBytes([]byte{10, 1, 0, 0}, 16))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"B1", "B2"}, tags)
+
+	found, tags = tree.GetExact(patricia.IPv4Address{})
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"ROOT"}, tags)
+
+	// only covered by parents
+	found, tags = tree.GetExact(ipv4FromBytes([]byte{10, 1, 3, 0}, 24))
+	assert.False(t, found)
+	assert.Equal(t, 0, len(tags))
+
+	// intermediate node with no tags
+	tree.Delete(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), func(GeneratedType, GeneratedType) bool { return true }, "")
+	found, tags = tree.GetExact(ipv4FromBytes([]byte{10, 1, 0, 0}, 16))
+	assert.False(t, found)
+	assert.Equal(t, 0, len(tags))
+
+	// shorter than an existing node
+	found, _ = tree.GetExact(ipv4FromBytes([]byte{10, 1, 0, 0}, 17))
+	assert.False(t, found)
+
+	buf := make([]GeneratedType, 0, 2)
+	found, buf = tree.GetExactAppend(buf, ipv4FromBytes([]byte{10, 1, 2, 0}, 24))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"C"}, buf)
+}
+
+func TestFindMatches(t *testing.T) {
+	tree := NewTreeV4()
+
+	address := ipv4FromBytes([]byte{123, 54, 66, 20}, 32)
+	assert.Equal(t, []MatchV4{}, tree.FindMatches(address))
+
+	tree.Add(ipv4FromBytes([]byte{123, 0, 0, 0}, 8), "HELLO", nil)
+	tree.Add(ipv4FromBytes([]byte{123, 54, 66, 20}, 32), "THERE", nil)
+	tree.Add(ipv4FromBytes([]byte{123, 54, 66, 0}, 24), "GOPHERS", nil)
+	tree.Add(ipv4FromBytes([]byte{123, 54, 66, 21}, 32), ":)", nil)
+
+	assert.Equal(t, []MatchV4{
+		{Prefix: ipv4FromBytes([]byte{123, 0, 0, 0}, 8), Tag: "HELLO"},
+		{Prefix: ipv4FromBytes([]byte{123, 54, 66, 0}, 24), Tag: "GOPHERS"},
+		{Prefix: ipv4FromBytes([]byte{123, 54, 66, 20}, 32), Tag: "THERE"},
+	}, tree.FindMatches(address))
+
+	// reuse a buffer, and include root tags
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	tree.Add(ipv4FromBytes([]byte{123, 54, 66, 0}, 24), "MORE", nil)
+	buf := make([]MatchV4, 0, 10)
+	buf = tree.FindMatchesAppend(buf, ipv4FromBytes([]byte{123, 54, 66, 99}, 32))
+	assert.Equal(t, []MatchV4{
+		{Prefix: ipv4FromBytes([]byte{0, 0, 0, 0}, 0), Tag: "ROOT"},
+		{Prefix: ipv4FromBytes([]byte{123, 0, 0, 0}, 8), Tag: "HELLO"},
+		{Prefix: ipv4FromBytes([]byte{123, 54, 66, 0}, 24), Tag: "GOPHERS"},
+		{Prefix: ipv4FromBytes([]byte{123, 54, 66, 0}, 24), Tag: "MORE"},
+	}, buf)
+
+	buf = tree.FindMatchesAppend(buf[:0], ipv4FromBytes([]byte{124, 0, 0, 0}, 8))
+	assert.Equal(t, []MatchV4{
+		{Prefix: ipv4FromBytes([]byte{0, 0, 0, 0}, 0), Tag: "ROOT"},
+	}, buf)
+}
+
 // test that the find functions don't destroy an address - too brittle and confusing for caller for what gains?
 func TestAddressReusable(t *testing.T) {
 	tags := make([]GeneratedType, 0)
@@ -1432,6 +1575,363 @@ func TestIterateV4(t *testing.T) {
 	assert.Equal(t, expected, got)
 }
 
+// test traversal of the part of the tree covered by an address
+func TestIterateSubtreeV4(t *testing.T) {
+	tree := NewTreeV4()
+
+	collect := func(address patricia.IPv4Address) [][]string {
+		got := [][]string{}
+		iter := tree.IterateSubtree(address)
+		for iter.Next() {
+			tags := []string{}
+			for _, s := range iter.Tags() { //nolint:gosimple
+				tags = append(tags, s.(string))
+			}
+			got = append(got, append([]string{iter.Address().String()}, tags...))
+		}
+		return got
+	}
+
+	// try an empty tree first
+	assert.Equal(t, [][]string{}, collect(ipv4FromBytes([]byte{10, 0, 0, 0}, 8)))
+
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 0}, 24), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{11, 0, 0, 0}, 8), "E", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "F", nil)
+
+	assert.Equal(t, [][]string{
+		{"10.0.0.0/8", "A"},
+		{"10.1.0.0/16", "B"},
+		{"10.1.2.0/24", "C"},
+		{"10.2.0.0/16", "D"},
+	}, collect(ipv4FromBytes([]byte{10, 0, 0, 0}, 8)))
+
+	assert.Equal(t, [][]string{
+		{"10.1.0.0/16", "B"},
+		{"10.1.2.0/24", "C"},
+	}, collect(ipv4FromBytes([]byte{10, 1, 0, 0}, 16)))
+
+	// no node at the exact address - everything below it is returned
+	assert.Equal(t, [][]string{
+		{"10.0.0.0/8", "A"},
+		{"10.1.0.0/16", "B"},
+		{"10.1.2.0/24", "C"},
+		{"10.2.0.0/16", "D"},
+		{"11.0.0.0/8", "E"},
+	}, collect(ipv4FromBytes([]byte{10, 0, 0, 0}, 7)))
+
+	// nothing covered
+	assert.Equal(t, [][]string{}, collect(ipv4FromBytes([]byte{10, 3, 0, 0}, 16)))
+	assert.Equal(t, [][]string{}, collect(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+
+	// the whole tree
+	assert.Equal(t, 7, len(collect(patricia.IPv4Address{})))
+
+	assert.Equal(t, []GeneratedType{"B", "C"}, tree.FindSubtreeTags(ipv4FromBytes([]byte{10, 1, 0, 0}, 16)))
+	assert.Equal(t, []GeneratedType{"F"}, tree.FindSubtreeTags(ipv4FromBytes([]byte{192, 0, 0, 0}, 8)))
+	assert.Equal(t, []GeneratedType{}, tree.FindSubtreeTags(ipv4FromBytes([]byte{172, 16, 0, 0}, 12)))
+
+	// deleting the root of the subtree ends the iteration
+	iter := tree.IterateSubtree(ipv4FromBytes([]byte{11, 0, 0, 0}, 8))
+	for iter.Next() {
+		iter.Delete(func(payload, val GeneratedType) bool {
+			return payload == "E"
+		}, "")
+	}
+	assert.Equal(t, [][]string{
+		{"0.0.0.0/0", "ROOT"},
+		{"10.0.0.0/8", "A"},
+		{"10.1.0.0/16", "B"},
+		{"10.1.2.0/24", "C"},
+		{"10.2.0.0/16", "D"},
+		{"192.168.0.0/16", "F"},
+	}, collect(patricia.IPv4Address{}))
+
+	// deleting within the subtree
+	iter = tree.IterateSubtree(ipv4FromBytes([]byte{10, 0, 0, 0}, 8))
+	for iter.Next() {
+		iter.Delete(func(payload, val GeneratedType) bool {
+			return payload == "A" || payload == "C"
+		}, "")
+	}
+	assert.Equal(t, [][]string{
+		{"0.0.0.0/0", "ROOT"},
+		{"10.1.0.0/16", "B"},
+		{"10.2.0.0/16", "D"},
+		{"192.168.0.0/16", "F"},
+	}, collect(patricia.IPv4Address{}))
+}
+
+func BenchmarkIterate(b *testing.B) {
+	tree := NewTreeV4()
+	for i := 0; i < 10000; i++ {
+		tree.Add(patricia.NewIPv4Address(rand.Uint32(), uint(rand.Intn(33))), "tag", nil)
+	}
+
+	iter := tree.Iterate()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		iter.Reset()
+		for iter.Next() {
+			_ = iter.Address()
+		}
+	}
+}
+
+// test that an iterator can be rewound and reused without allocating
+func TestIterateResetV4(t *testing.T) {
+	tree := NewTreeV4()
+	for i := 0; i < 1000; i++ {
+		tree.Add(patricia.NewIPv4Address(rand.Uint32(), uint(rand.Intn(33))), "tag", nil)
+	}
+
+	collect := func(iter *TreeIteratorV4) []patricia.IPv4Address {
+		got := []patricia.IPv4Address{}
+		for iter.Next() {
+			got = append(got, iter.Address())
+		}
+		return got
+	}
+
+	from := patricia.NewIPv4Address(uint32(0x40000000), 2)
+	to := patricia.NewIPv4Address(uint32(0xC0000000), 2)
+	for _, iter := range []*TreeIteratorV4{
+		tree.Iterate(),
+		tree.IterateReverse(),
+		tree.IterateFrom(from),
+		tree.IterateRange(from, to),
+		tree.IterateSubtree(from),
+	} {
+		expected := collect(iter)
+		assert.NotEqual(t, 0, len(expected))
+
+		iter.Reset()
+		assert.Equal(t, expected, collect(iter))
+
+		// reset in the middle of an iteration
+		iter.Reset()
+		iter.Next()
+		iter.Next()
+		iter.Reset()
+		assert.Equal(t, expected, collect(iter))
+
+		allocs := testing.AllocsPerRun(10, func() {
+			iter.Reset()
+			for iter.Next() {
+				_ = iter.Address()
+			}
+		})
+		assert.Equal(t, float64(0), allocs)
+	}
+}
+
+// test that the address is tracked correctly when deleting during traversal
+func TestIterateAddressAfterDeleteV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	for i := 0; i < 2000; i++ {
+		tree.Add(patricia.NewIPv4Address(rand.Uint32(), uint(rand.Intn(33))), "tag", nil)
+	}
+
+	all := []patricia.IPv4Address{}
+	iter := tree.Iterate()
+	for iter.Next() {
+		all = append(all, iter.Address())
+	}
+
+	// delete every third node while iterating
+	i := 0
+	expected := []patricia.IPv4Address{}
+	iter = tree.Iterate()
+	for iter.Next() {
+		assert.Equal(t, all[i], iter.Address())
+		if i%3 == 1 {
+			iter.Delete(func(GeneratedType, GeneratedType) bool { return true }, "")
+		} else {
+			expected = append(expected, iter.Address())
+		}
+		i++
+	}
+	assert.Equal(t, len(all), i)
+
+	got := []patricia.IPv4Address{}
+	iter = tree.Iterate()
+	for iter.Next() {
+		got = append(got, iter.Address())
+	}
+	assert.Equal(t, expected, got)
+}
+
+// test range-over-func traversal
+func TestRangeV4(t *testing.T) {
+	tree := NewTreeV4()
+
+	for range tree.All() {
+		assert.Fail(t, "empty tree should not have an element")
+	}
+
+	tree.Add(ipv4FromBytes([]byte{203, 143, 220, 0}, 23), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{203, 143, 220, 198}, 32), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{203, 143, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{203, 143, 221, 75}, 32), "D1", nil)
+	tree.Add(ipv4FromBytes([]byte{203, 143, 221, 75}, 32), "D2", nil)
+
+	addresses := []string{}
+	allTags := [][]GeneratedType{}
+	for address, tags := range tree.All() {
+		addresses = append(addresses, address.String())
+		allTags = append(allTags, tags)
+	}
+	assert.Equal(t, []string{"203.143.0.0/16", "203.143.220.0/23", "203.143.220.198/32", "203.143.221.75/32"}, addresses)
+	assert.Equal(t, [][]GeneratedType{{"C"}, {"A"}, {"B"}, {"D1", "D2"}}, allTags)
+
+	prefixes := []string{}
+	for address := range tree.Prefixes() {
+		prefixes = append(prefixes, address.String())
+	}
+	assert.Equal(t, addresses, prefixes)
+
+	tags := []GeneratedType{}
+	for tag := range tree.Tags() {
+		tags = append(tags, tag)
+	}
+	assert.Equal(t, []GeneratedType{"C", "A", "B", "D1", "D2"}, tags)
+
+	// stopping early
+	tags = tags[:0]
+	for tag := range tree.Tags() {
+		tags = append(tags, tag)
+		if tag == "D1" {
+			break
+		}
+	}
+	assert.Equal(t, []GeneratedType{"C", "A", "B", "D1"}, tags)
+
+	count := 0
+	for range tree.All() {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+}
+
+// test starting the traversal somewhere in the middle of the tree
+func TestIterateFromV4(t *testing.T) {
+	tree := NewTreeV4()
+
+	collect := func(iter *TreeIteratorV4) []string {
+		got := []string{}
+		for iter.Next() {
+			got = append(got, iter.Address().String())
+		}
+		return got
+	}
+
+	// try an empty tree first
+	assert.Equal(t, []string{}, collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 0, 0, 0}, 8))))
+
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 0}, 24), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{11, 0, 0, 0}, 8), "E", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "F", nil)
+
+	all := []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "11.0.0.0/8", "192.168.0.0/16"}
+	assert.Equal(t, all, collect(tree.Iterate()))
+	assert.Equal(t, all, collect(tree.IterateFrom(patricia.IPv4Address{})))
+
+	// starting at each existing address
+	for i, address := range all {
+		v4, _, err := patricia.ParseIPFromString(address)
+		assert.NoError(t, err)
+		assert.Equal(t, all[i:], collect(tree.IterateFrom(*v4)))
+	}
+
+	// starting between existing addresses
+	assert.Equal(t, all[1:], collect(tree.IterateFrom(ipv4FromBytes([]byte{1, 0, 0, 0}, 8))))
+	assert.Equal(t, all[1:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 0, 0, 0}, 7))))
+	assert.Equal(t, all[2:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 0, 0, 0}, 9))))
+	assert.Equal(t, all[3:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 1, 0, 0}, 17))))
+	assert.Equal(t, all[4:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 1, 2, 1}, 32))))
+	assert.Equal(t, all[5:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 3, 0, 0}, 16))))
+	assert.Equal(t, all[6:], collect(tree.IterateFrom(ipv4FromBytes([]byte{11, 0, 0, 1}, 32))))
+	assert.Equal(t, []string{}, collect(tree.IterateFrom(ipv4FromBytes([]byte{192, 168, 0, 1}, 32))))
+	assert.Equal(t, []string{}, collect(tree.IterateFrom(ipv4FromBytes([]byte{255, 255, 255, 255}, 32))))
+
+	// host bits are ignored
+	assert.Equal(t, all[2:], collect(tree.IterateFrom(ipv4FromBytes([]byte{10, 1, 99, 99}, 16))))
+
+	// ranges
+	assert.Equal(t, all[1:4], collect(tree.IterateRange(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), ipv4FromBytes([]byte{10, 2, 0, 0}, 16))))
+	assert.Equal(t, all[1:5], collect(tree.IterateRange(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), ipv4FromBytes([]byte{10, 2, 0, 0}, 17))))
+	assert.Equal(t, all[:6], collect(tree.IterateRange(patricia.IPv4Address{}, ipv4FromBytes([]byte{128, 0, 0, 0}, 1))))
+	assert.Equal(t, []string{}, collect(tree.IterateRange(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), ipv4FromBytes([]byte{10, 0, 0, 0}, 8))))
+	assert.Equal(t, []string{}, collect(tree.IterateRange(ipv4FromBytes([]byte{11, 0, 0, 0}, 8), ipv4FromBytes([]byte{10, 0, 0, 0}, 8))))
+
+	// the iterator stays done
+	iter := tree.IterateRange(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), ipv4FromBytes([]byte{10, 1, 0, 0}, 16))
+	assert.True(t, iter.Next())
+	assert.False(t, iter.Next())
+	assert.False(t, iter.Next())
+}
+
+// test paging through a larger tree
+func TestIterateFromPagingV4(t *testing.T) {
+	tree := NewTreeV4()
+	for i := 0; i < 2000; i++ {
+		tree.Add(patricia.NewIPv4Address(rand.Uint32(), uint(rand.Intn(33))), "tag", nil)
+	}
+
+	all := []patricia.IPv4Address{}
+	iter := tree.Iterate()
+	for iter.Next() {
+		all = append(all, iter.Address())
+	}
+
+	pageSize := 100
+	got := []patricia.IPv4Address{}
+	iter = tree.Iterate()
+	for {
+		page := 0
+		for iter.Next() {
+			address := iter.Address()
+			if len(got) > 0 && got[len(got)-1] == address {
+				// the first result on a page is the last one on the previous page
+				continue
+			}
+			got = append(got, address)
+			page++
+			if page == pageSize {
+				break
+			}
+		}
+		if page == 0 {
+			break
+		}
+		iter = tree.IterateFrom(got[len(got)-1])
+	}
+	assert.Equal(t, all, got)
+
+	// ranges line up with the full iteration
+	for i := 0; i < 100; i++ {
+		from := rand.Intn(len(all))
+		to := from + rand.Intn(len(all)-from)
+		got = got[:0]
+		iter = tree.IterateRange(all[from], all[to])
+		for iter.Next() {
+			got = append(got, iter.Address())
+		}
+		assert.Equal(t, all[from:to], got)
+	}
+}
+
 // test deletion during tree traversal
 func TestIterateAndDeleteV4(t *testing.T) {
 	tree := NewTreeV4()
@@ -1613,3 +2113,1244 @@ func TestIterateAndDeleteV4(t *testing.T) {
 		{"203.143.221.75/32", "D"},
 	})
 }
+
+// test reverse tree traversal
+func TestIterateReverseV4(t *testing.T) {
+	tree := NewTreeV4()
+
+	// try an empty tree first
+	iter := tree.IterateReverse()
+	for iter.Next() {
+		assert.Fail(t, "empty tree should not have a next element")
+	}
+
+	tree.Add(patricia.IPv4Address{}, "ROOT", nil)
+	for i := 0; i < 2000; i++ {
+		tree.Add(patricia.NewIPv4Address(rand.Uint32(), uint(rand.Intn(33))), fmt.Sprintf("%d", i), nil)
+	}
+
+	forward := []patricia.IPv4Address{}
+	iter = tree.Iterate()
+	for iter.Next() {
+		forward = append(forward, iter.Address())
+	}
+
+	reverse := []patricia.IPv4Address{}
+	iter = tree.IterateReverse()
+	for iter.Next() {
+		reverse = append(reverse, iter.Address())
+	}
+
+	assert.Equal(t, len(forward), len(reverse))
+	for i := range forward {
+		assert.Equal(t, forward[i], reverse[len(reverse)-1-i])
+	}
+
+	// delete every other node while iterating backwards
+	i := 0
+	expected := []patricia.IPv4Address{}
+	iter = tree.IterateReverse()
+	for iter.Next() {
+		assert.Equal(t, reverse[i], iter.Address())
+		if i%2 == 0 {
+			iter.Delete(func(GeneratedType, GeneratedType) bool { return true }, "")
+		} else {
+			expected = append(expected, iter.Address())
+		}
+		i++
+	}
+	assert.Equal(t, len(reverse), i)
+
+	got := []patricia.IPv4Address{}
+	iter = tree.IterateReverse()
+	for iter.Next() {
+		got = append(got, iter.Address())
+	}
+	assert.Equal(t, expected, got)
+}
+
+// test deletion during reverse tree traversal
+func TestIterateReverseAndDeleteV4(t *testing.T) {
+	compare := func(tree *TreeV4, expected [][]string) {
+		t.Helper()
+		got := [][]string{}
+		iter := tree.IterateReverse()
+		for iter.Next() {
+			tags := []string{}
+			for _, s := range iter.Tags() { //nolint:gosimple
+				tags = append(tags, s.(string))
+			}
+			got = append(got, append([]string{iter.Address().String()}, tags...))
+		}
+		assert.Equal(t, expected, got)
+	}
+	deleteTag := func(tree *TreeV4, tag string) {
+		iter := tree.IterateReverse()
+		for iter.Next() {
+			iter.Delete(func(payload, val GeneratedType) bool {
+				return payload == tag
+			}, "")
+		}
+	}
+
+	ipA := ipv4FromBytes([]byte{203, 143, 220, 0}, 23)
+	ipB := ipv4FromBytes([]byte{203, 143, 220, 198}, 31)
+	ipC := ipv4FromBytes([]byte{203, 143, 0, 0}, 16)
+	ipD := ipv4FromBytes([]byte{203, 143, 221, 75}, 32)
+	ipE := ipv4FromBytes([]byte{203, 143, 220, 198}, 32)
+
+	tree := NewTreeV4()
+	tree.Add(ipA, "A", nil)
+	tree.Add(ipB, "B", nil)
+	tree.Add(ipC, "C", nil)
+	tree.Add(ipD, "D1", nil)
+	tree.Add(ipD, "D2", nil)
+	compare(tree, [][]string{
+		{"203.143.221.75/32", "D1", "D2"},
+		{"203.143.220.198/31", "B"},
+		{"203.143.220.0/23", "A"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete one tag, no node
+	deleteTag(tree, "D1")
+	compare(tree, [][]string{
+		{"203.143.221.75/32", "D2"},
+		{"203.143.220.198/31", "B"},
+		{"203.143.220.0/23", "A"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete a node with two children
+	deleteTag(tree, "A")
+	compare(tree, [][]string{
+		{"203.143.221.75/32", "D2"},
+		{"203.143.220.198/31", "B"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete right children of a node two children
+	deleteTag(tree, "D2")
+	compare(tree, [][]string{
+		{"203.143.220.198/31", "B"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete root
+	deleteTag(tree, "C")
+	compare(tree, [][]string{
+		{"203.143.220.198/31", "B"},
+	})
+
+	// Delete a node with a left children only
+	tree = NewTreeV4()
+	tree.Add(ipA, "A", nil)
+	tree.Add(ipB, "B", nil)
+	tree.Add(ipC, "C", nil)
+	deleteTag(tree, "A")
+	compare(tree, [][]string{
+		{"203.143.220.198/31", "B"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete a node without children and at the left of its empty parent
+	tree = NewTreeV4()
+	tree.Add(ipB, "B", nil)
+	tree.Add(ipC, "C", nil)
+	tree.Add(ipD, "D", nil)
+	deleteTag(tree, "B")
+	compare(tree, [][]string{
+		{"203.143.221.75/32", "D"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete a node without children and at the right of its empty parent
+	tree = NewTreeV4()
+	tree.Add(ipB, "B", nil)
+	tree.Add(ipC, "C", nil)
+	tree.Add(ipD, "D", nil)
+	deleteTag(tree, "D")
+	compare(tree, [][]string{
+		{"203.143.220.198/31", "B"},
+		{"203.143.0.0/16", "C"},
+	})
+
+	// Delete left node with a child
+	tree = NewTreeV4()
+	tree.Add(ipA, "A", nil)
+	tree.Add(ipB, "B", nil)
+	tree.Add(ipC, "C", nil)
+	tree.Add(ipD, "D", nil)
+	tree.Add(ipE, "E", nil)
+	deleteTag(tree, "B")
+	compare(tree, [][]string{
+		{"203.143.221.75/32", "D"},
+		{"203.143.220.198/32", "E"},
+		{"203.143.220.0/23", "A"},
+		{"203.143.0.0/16", "C"},
+	})
+}
+
+func TestSetOperationsV4(t *testing.T) {
+	contents := func(tree *TreeV4) map[string][]GeneratedType {
+		ret := map[string][]GeneratedType{}
+		for address, tags := range tree.All() {
+			ret[address.String()] = tags
+		}
+		return ret
+	}
+
+	left := NewTreeV4()
+	left.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "L1", nil)
+	left.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "L2", nil)
+	left.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "L3", nil)
+	left.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "L4", nil)
+
+	right := NewTreeV4()
+	right.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "R1", nil)
+	right.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "R2", nil)
+	right.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "R3", nil)
+	right.Add(patricia.IPv4Address{}, "R4", nil)
+
+	assert.Equal(t, map[string][]GeneratedType{
+		"0.0.0.0/0":      {"R4"},
+		"10.0.0.0/8":     {"L1", "R1"},
+		"10.1.0.0/16":    {"L2"},
+		"10.2.0.0/16":    {"R2"},
+		"192.168.0.0/16": {"L3", "L4", "R3"},
+	}, contents(left.Union(right, nil)))
+
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/8":     {"L1"},
+		"192.168.0.0/16": {"L3", "L4"},
+	}, contents(left.Intersect(right, nil)))
+
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.1.0.0/16": {"L2"},
+	}, contents(left.Difference(right, nil)))
+
+	// custom resolution
+	pickRight := func(left []GeneratedType, right []GeneratedType) []GeneratedType {
+		return right
+	}
+	assert.Equal(t, map[string][]GeneratedType{
+		"0.0.0.0/0":      {"R4"},
+		"10.0.0.0/8":     {"R1"},
+		"10.1.0.0/16":    {"L2"},
+		"10.2.0.0/16":    {"R2"},
+		"192.168.0.0/16": {"R3"},
+	}, contents(left.Union(right, pickRight)))
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/8":     {"R1"},
+		"192.168.0.0/16": {"R3"},
+	}, contents(left.Intersect(right, pickRight)))
+
+	// keep the tags from the left which are not on the right
+	remaining := func(left []GeneratedType, right []GeneratedType) []GeneratedType {
+		ret := []GeneratedType{}
+		for _, tag := range left {
+			if tag != "L4" {
+				ret = append(ret, tag)
+			}
+		}
+		return ret
+	}
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/8":     {"L1"},
+		"10.1.0.0/16":    {"L2"},
+		"192.168.0.0/16": {"L3"},
+	}, contents(left.Difference(right, remaining)))
+
+	// the inputs are left alone
+	assert.Equal(t, 4, left.CountTags())
+	assert.Equal(t, 4, right.CountTags())
+
+	// empty trees
+	empty := NewTreeV4()
+	assert.Equal(t, contents(left), contents(left.Union(empty, nil)))
+	assert.Equal(t, contents(left), contents(empty.Union(left, nil)))
+	assert.Equal(t, map[string][]GeneratedType{}, contents(left.Intersect(empty, nil)))
+	assert.Equal(t, contents(left), contents(left.Difference(empty, nil)))
+	assert.Equal(t, map[string][]GeneratedType{}, contents(empty.Difference(left, nil)))
+
+	// only identical prefixes interact - covering and covered prefixes are left alone
+	covering := NewTreeV4()
+	covering.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "C1", nil)
+	covered := NewTreeV4()
+	covered.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "C2", nil)
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/8":  {"C1"},
+		"10.1.0.0/16": {"C2"},
+	}, contents(covering.Union(covered, nil)))
+	assert.Equal(t, map[string][]GeneratedType{}, contents(covering.Intersect(covered, nil)))
+	assert.Equal(t, map[string][]GeneratedType{}, contents(covered.Intersect(covering, nil)))
+	assert.Equal(t, contents(covered), contents(covered.Difference(covering, nil)))
+	assert.Equal(t, contents(covering), contents(covering.Difference(covered, nil)))
+
+	// the resulting trees can be searched
+	union := left.Union(right, nil)
+	assert.Equal(t, []GeneratedType{"R4", "L1", "R1", "L2"}, union.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+}
+
+func TestDiffV4(t *testing.T) {
+	matchFunc := func(a GeneratedType, b GeneratedType) bool { return a == b }
+	collect := func(oldTree *TreeV4, newTree *TreeV4) []string {
+		ret := []string{}
+		for diff := range oldTree.Diff(newTree, matchFunc) {
+			ret = append(ret, fmt.Sprintf("%d %s %v %v", diff.Type, diff.Address, diff.OldTags, diff.NewTags))
+		}
+		return ret
+	}
+
+	oldTree := NewTreeV4()
+	newTree := NewTreeV4()
+	assert.Equal(t, []string{}, collect(oldTree, newTree))
+
+	oldTree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	oldTree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	oldTree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "C1", nil)
+	oldTree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "C2", nil)
+	oldTree.Add(ipv4FromBytes([]byte{10, 3, 0, 0}, 16), "D", nil)
+
+	newTree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	newTree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "C2", nil)
+	newTree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "C1", nil)
+	newTree.Add(ipv4FromBytes([]byte{10, 3, 0, 0}, 16), "E", nil)
+	newTree.Add(ipv4FromBytes([]byte{10, 3, 0, 0}, 24), "F", nil)
+	newTree.Add(patricia.IPv4Address{}, "G", nil)
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d 0.0.0.0/0 [] [G]", DiffAdded),
+		fmt.Sprintf("%d 10.1.0.0/16 [B] []", DiffRemoved),
+		fmt.Sprintf("%d 10.3.0.0/16 [D] [E]", DiffChanged),
+		fmt.Sprintf("%d 10.3.0.0/24 [] [F]", DiffAdded),
+	}, collect(oldTree, newTree))
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d 0.0.0.0/0 [G] []", DiffRemoved),
+		fmt.Sprintf("%d 10.1.0.0/16 [] [B]", DiffAdded),
+		fmt.Sprintf("%d 10.3.0.0/16 [E] [D]", DiffChanged),
+		fmt.Sprintf("%d 10.3.0.0/24 [F] []", DiffRemoved),
+	}, collect(newTree, oldTree))
+
+	// no differences
+	assert.Equal(t, []string{}, collect(oldTree, oldTree.Clone()))
+
+	// a different number of tags is a change, even if they all match
+	newTree = oldTree.Clone()
+	newTree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d 10.1.0.0/16 [B] [B B]", DiffChanged),
+	}, collect(oldTree, newTree))
+
+	// duplicated tags must appear the same number of times
+	oldTree = NewTreeV4()
+	newTree = NewTreeV4()
+	for _, tag := range []string{"A", "A", "B"} {
+		oldTree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), tag, nil)
+	}
+	for _, tag := range []string{"A", "B", "B"} {
+		newTree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), tag, nil)
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d 10.1.0.0/16 [A A B] [A B B]", DiffChanged),
+	}, collect(oldTree, newTree))
+
+	// stopping early
+	count := 0
+	for range oldTree.Diff(NewTreeV4(), matchFunc) {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestAggregateV4(t *testing.T) {
+	matchFunc := func(a GeneratedType, b GeneratedType) bool { return a == b }
+	contents := func(tree *TreeV4) map[string][]GeneratedType {
+		ret := map[string][]GeneratedType{}
+		for address, tags := range tree.All() {
+			ret[address.String()] = tags
+		}
+		return ret
+	}
+
+	// nothing to do
+	tree := NewTreeV4()
+	assert.Equal(t, 0, tree.Aggregate(matchFunc))
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 24), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 2, 0}, 24), "A", nil)
+	assert.Equal(t, 0, tree.Aggregate(matchFunc))
+
+	// 256 adjacent /24s collapse into a /16
+	tree = NewTreeV4()
+	for i := 0; i < 256; i++ {
+		tree.Add(ipv4FromBytes([]byte{10, 1, byte(i), 0}, 24), "A", nil)
+	}
+	assert.Equal(t, 255, tree.Aggregate(matchFunc))
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.1.0.0/16": {"A"},
+	}, contents(tree))
+
+	// more specifics repeating their parent's tags are removed, in any order
+	tree = NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 1, 0}, 24), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 1, 1}, 32), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 1, 1}, 32), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 0}, 24), "A", nil)
+	assert.Equal(t, 1, tree.Aggregate(matchFunc))
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/8":  {"A", "B"},
+		"10.1.1.0/24": {"C"},
+		"10.1.1.1/32": {"B", "A"},
+		"10.1.2.0/24": {"A"},
+	}, contents(tree))
+
+	// siblings aren't merged into a supernet with different tags
+	tree = NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 23), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 24), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 1, 0}, 24), "A", nil)
+	assert.Equal(t, 0, tree.Aggregate(matchFunc))
+
+	// duplicated tags must appear the same number of times to match
+	tree = NewTreeV4()
+	for _, tag := range []string{"A", "A", "B"} {
+		tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), tag, nil)
+		tree.Add(ipv4FromBytes([]byte{11, 2, 0, 0}, 24), tag, nil)
+	}
+	for _, tag := range []string{"A", "B", "B"} {
+		tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), tag, nil)
+		tree.Add(ipv4FromBytes([]byte{11, 2, 1, 0}, 24), tag, nil)
+	}
+	assert.Equal(t, 0, tree.Aggregate(matchFunc))
+	assert.Equal(t, 12, tree.CountTags())
+
+	// merged siblings can make other prefixes redundant
+	tree = NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 24), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 1, 0}, 24), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 1, 0}, 25), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 2, 0}, 23), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 2, 7}, 32), "B", nil)
+	assert.Equal(t, 3, tree.Aggregate(matchFunc))
+	assert.Equal(t, map[string][]GeneratedType{
+		"10.0.0.0/22": {"A"},
+		"10.0.2.7/32": {"B"},
+	}, contents(tree))
+}
+
+// test that aggregating doesn't change the result of lookups
+func TestAggregateRandomV4(t *testing.T) {
+	matchFunc := func(a GeneratedType, b GeneratedType) bool { return a == b }
+
+	tree := NewTreeV4()
+	for i := 0; i < 5000; i++ {
+		address := patricia.NewIPv4Address(uint32(0x0A000000)|(rand.Uint32()>>20), uint(20+rand.Intn(13)))
+		tree.Set(address, fmt.Sprintf("%d", rand.Intn(3)))
+	}
+	original := tree.Clone()
+	before := tree.CountTags()
+
+	eliminated := tree.Aggregate(matchFunc)
+	assert.True(t, eliminated > 0)
+	assert.Equal(t, before-eliminated, tree.CountTags())
+
+	for i := uint32(0); i < 1<<12; i++ {
+		address := patricia.NewIPv4Address(uint32(0x0A000000)|i, 32)
+		_, expected := original.FindDeepestTags(address)
+		_, got := tree.FindDeepestTags(address)
+		assert.Equal(t, expected, got)
+	}
+
+	// nothing else to do
+	assert.Equal(t, 0, tree.Aggregate(matchFunc))
+}
+
+func TestAddRangeV4(t *testing.T) {
+	tree := NewTreeV4()
+	assert.Equal(t, 2, tree.AddRange(ipv4FromBytes([]byte{192, 168, 0, 0}, 32), ipv4FromBytes([]byte{192, 168, 2, 255}, 32), "A", nil))
+	assert.Equal(t, 2, tree.AddRange(ipv4FromBytes([]byte{192, 168, 0, 0}, 32), ipv4FromBytes([]byte{192, 168, 2, 255}, 32), "A",
+		func(a GeneratedType, b GeneratedType) bool { return a == b }))
+	assert.Equal(t, 1, tree.AddRange(ipv4FromBytes([]byte{192, 168, 2, 0}, 32), ipv4FromBytes([]byte{192, 168, 2, 255}, 32), "B", nil))
+	assert.Equal(t, 0, tree.AddRange(ipv4FromBytes([]byte{192, 168, 2, 255}, 32), ipv4FromBytes([]byte{192, 168, 2, 0}, 32), "C", nil))
+
+	prefixes := map[string][]GeneratedType{}
+	for address, tags := range tree.All() {
+		prefixes[address.String()] = tags
+	}
+	assert.Equal(t, map[string][]GeneratedType{
+		"192.168.0.0/23": {"A"},
+		"192.168.2.0/24": {"A", "B"},
+	}, prefixes)
+
+	assert.Equal(t, 1, tree.SetRange(ipv4FromBytes([]byte{192, 168, 0, 0}, 32), ipv4FromBytes([]byte{192, 168, 1, 255}, 32), "C"))
+	_, tags := tree.GetExact(ipv4FromBytes([]byte{192, 168, 0, 0}, 23))
+	assert.Equal(t, []GeneratedType{"C"}, tags)
+
+	found, tag := tree.FindDeepestTag(ipv4FromBytes([]byte{192, 168, 1, 7}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "C", tag)
+	found, _ = tree.FindDeepestTag(ipv4FromBytes([]byte{192, 168, 3, 0}, 32))
+	assert.False(t, found)
+}
+
+func TestFindOverlappingV4(t *testing.T) {
+	tree := NewTreeV4()
+	assert.Empty(t, tree.FindOverlapping(ipv4FromBytes([]byte{10, 1, 0, 0}, 16)))
+
+	tree.Add(ipv4FromBytes([]byte{0, 0, 0, 0}, 0), "root", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 0}, 24), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 3}, 32), "E", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 128, 0}, 17), "F", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "G", nil)
+	tree.Add(ipv4FromBytes([]byte{11, 0, 0, 0}, 8), "H", nil)
+
+	// host bits are ignored
+	assert.Equal(t, []OverlapV4{
+		{Prefix: ipv4FromBytes([]byte{0, 0, 0, 0}, 0), Tags: []GeneratedType{"root"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 0, 0, 0}, 8), Tags: []GeneratedType{"A", "B"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 0, 0}, 16), Tags: []GeneratedType{"C"}, Type: OverlapExact},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 2, 0}, 24), Tags: []GeneratedType{"D"}, Type: OverlapCovered},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 2, 3}, 32), Tags: []GeneratedType{"E"}, Type: OverlapCovered},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 128, 0}, 17), Tags: []GeneratedType{"F"}, Type: OverlapCovered},
+	}, tree.FindOverlapping(ipv4FromBytes([]byte{10, 1, 7, 7}, 16)))
+
+	// no node at the address
+	assert.Equal(t, []OverlapV4{
+		{Prefix: ipv4FromBytes([]byte{0, 0, 0, 0}, 0), Tags: []GeneratedType{"root"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 0, 0, 0}, 8), Tags: []GeneratedType{"A", "B"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 0, 0}, 16), Tags: []GeneratedType{"C"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 2, 0}, 24), Tags: []GeneratedType{"D"}, Type: OverlapCovers},
+		{Prefix: ipv4FromBytes([]byte{10, 1, 2, 3}, 32), Tags: []GeneratedType{"E"}, Type: OverlapCovered},
+	}, tree.FindOverlapping(ipv4FromBytes([]byte{10, 1, 2, 0}, 30)))
+
+	// the whole tree
+	overlaps := tree.FindOverlapping(ipv4FromBytes([]byte{0, 0, 0, 0}, 0))
+	assert.Equal(t, 8, len(overlaps))
+	assert.Equal(t, OverlapExact, overlaps[0].Type)
+	assert.Equal(t, OverlapCovered, overlaps[7].Type)
+
+	// only supernets
+	tree.Delete(ipv4FromBytes([]byte{0, 0, 0, 0}, 0), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.Equal(t, []OverlapV4{
+		{Prefix: ipv4FromBytes([]byte{11, 0, 0, 0}, 8), Tags: []GeneratedType{"H"}, Type: OverlapCovers},
+	}, tree.FindOverlapping(ipv4FromBytes([]byte{11, 1, 1, 1}, 32)))
+	assert.Empty(t, tree.FindOverlapping(ipv4FromBytes([]byte{12, 1, 1, 1}, 32)))
+}
+
+func TestAllocateV4(t *testing.T) {
+	pool := ipv4FromBytes([]byte{10, 20, 0, 0}, 24)
+	tree := NewTreeV4()
+	tree.Add(pool, "pool", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "supernet", nil)
+
+	// tags at and above the pool don't count - allocations are handed out in order
+	for i := 0; i < 4; i++ {
+		ok, prefix := tree.Allocate(pool, 26, "A")
+		assert.True(t, ok)
+		assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, byte(i * 64)}, 26), prefix)
+	}
+	ok, _ := tree.Allocate(pool, 26, "A")
+	assert.False(t, ok)
+	ok, _ = tree.Allocate(pool, 32, "A")
+	assert.False(t, ok)
+
+	// released prefixes are reused
+	assert.Equal(t, 1, tree.Release(ipv4FromBytes([]byte{10, 20, 0, 64}, 26)))
+	ok, prefix := tree.Allocate(pool, 28, "B")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 64}, 28), prefix)
+	_, tags := tree.GetExact(prefix)
+	assert.Equal(t, []GeneratedType{"B"}, tags)
+
+	// invalid lengths
+	ok, _ = tree.Allocate(pool, 23, "C")
+	assert.False(t, ok)
+	ok, _ = tree.Allocate(pool, 33, "C")
+	assert.False(t, ok)
+
+	// more specifics of existing allocations aren't handed out
+	tree = NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 20, 0, 0}, 25), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 20, 0, 4}, 30), "B", nil)
+	ok, prefix = tree.Allocate(pool, 30, "C")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 128}, 30), prefix)
+
+	// the whole address space
+	tree = NewTreeV4()
+	ok, prefix = tree.Allocate(ipv4FromBytes([]byte{0, 0, 0, 0}, 0), 0, "A")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{0, 0, 0, 0}, 0), prefix)
+}
+
+func TestAllocateBestFitV4(t *testing.T) {
+	pool := ipv4FromBytes([]byte{10, 20, 0, 0}, 24)
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 20, 0, 0}, 26), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 20, 0, 192}, 27), "A", nil)
+
+	// free: 10.20.0.64/26, 10.20.0.128/26, 10.20.0.224/27
+	ok, prefix := tree.AllocateBestFit(pool, 28, "B")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 224}, 28), prefix)
+
+	ok, prefix = tree.Allocate(pool, 28, "B")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 64}, 28), prefix)
+
+	// free: 10.20.0.80/28, 10.20.0.96/27, 10.20.0.128/26, 10.20.0.240/28
+	ok, prefix = tree.AllocateBestFit(pool, 27, "C")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 96}, 27), prefix)
+	ok, prefix = tree.AllocateBestFit(pool, 26, "C")
+	assert.True(t, ok)
+	assert.Equal(t, ipv4FromBytes([]byte{10, 20, 0, 128}, 26), prefix)
+	ok, _ = tree.AllocateBestFit(pool, 26, "C")
+	assert.False(t, ok)
+}
+
+func TestGapsV4(t *testing.T) {
+	within := ipv4FromBytes([]byte{100, 64, 0, 0}, 10)
+	tree := NewTreeV4()
+	assert.Equal(t, []patricia.IPv4Address{within}, tree.Gaps(within))
+
+	// tags at and above the prefix are ignored
+	tree.Add(ipv4FromBytes([]byte{100, 0, 0, 0}, 8), "A", nil)
+	tree.Add(within, "B", nil)
+	assert.Equal(t, []patricia.IPv4Address{within}, tree.Gaps(ipv4FromBytes([]byte{100, 64, 1, 1}, 10)))
+
+	tree.Add(ipv4FromBytes([]byte{100, 64, 0, 0}, 12), "POP1", nil)
+	tree.Add(ipv4FromBytes([]byte{100, 64, 0, 0}, 24), "POP1", nil)
+	tree.Add(ipv4FromBytes([]byte{100, 96, 0, 0}, 11), "POP2", nil)
+	tree.Add(ipv4FromBytes([]byte{100, 80, 0, 1}, 32), "POP3", nil)
+	tree.Add(ipv4FromBytes([]byte{101, 0, 0, 0}, 16), "elsewhere", nil)
+	assert.Equal(t, []patricia.IPv4Address{
+		ipv4FromBytes([]byte{100, 80, 0, 0}, 32),
+		ipv4FromBytes([]byte{100, 80, 0, 2}, 31),
+		ipv4FromBytes([]byte{100, 80, 0, 4}, 30),
+		ipv4FromBytes([]byte{100, 80, 0, 8}, 29),
+		ipv4FromBytes([]byte{100, 80, 0, 16}, 28),
+		ipv4FromBytes([]byte{100, 80, 0, 32}, 27),
+		ipv4FromBytes([]byte{100, 80, 0, 64}, 26),
+		ipv4FromBytes([]byte{100, 80, 0, 128}, 25),
+		ipv4FromBytes([]byte{100, 80, 1, 0}, 24),
+		ipv4FromBytes([]byte{100, 80, 2, 0}, 23),
+		ipv4FromBytes([]byte{100, 80, 4, 0}, 22),
+		ipv4FromBytes([]byte{100, 80, 8, 0}, 21),
+		ipv4FromBytes([]byte{100, 80, 16, 0}, 20),
+		ipv4FromBytes([]byte{100, 80, 32, 0}, 19),
+		ipv4FromBytes([]byte{100, 80, 64, 0}, 18),
+		ipv4FromBytes([]byte{100, 80, 128, 0}, 17),
+		ipv4FromBytes([]byte{100, 81, 0, 0}, 16),
+		ipv4FromBytes([]byte{100, 82, 0, 0}, 15),
+		ipv4FromBytes([]byte{100, 84, 0, 0}, 14),
+		ipv4FromBytes([]byte{100, 88, 0, 0}, 13),
+	}, tree.Gaps(within))
+
+	// only tags below the prefix count
+	assert.Equal(t, []patricia.IPv4Address{
+		ipv4FromBytes([]byte{100, 64, 1, 0}, 24),
+	}, tree.Gaps(ipv4FromBytes([]byte{100, 64, 0, 0}, 23)))
+	assert.Equal(t, []patricia.IPv4Address{
+		ipv4FromBytes([]byte{100, 96, 0, 0}, 16),
+	}, tree.Gaps(ipv4FromBytes([]byte{100, 96, 0, 0}, 16)))
+	assert.Equal(t, []patricia.IPv4Address{
+		ipv4FromBytes([]byte{100, 80, 0, 0}, 32),
+	}, tree.Gaps(ipv4FromBytes([]byte{100, 80, 0, 0}, 31)))
+	tree.Add(ipv4FromBytes([]byte{100, 80, 0, 0}, 32), "POP3", nil)
+	assert.Empty(t, tree.Gaps(ipv4FromBytes([]byte{100, 80, 0, 0}, 31)))
+}
+
+func TestFindNeighborsV4(t *testing.T) {
+	tree := NewTreeV4()
+	predecessor, successor := tree.FindNeighbors(ipv4FromBytes([]byte{10, 0, 0, 1}, 32))
+	assert.False(t, predecessor.Found)
+	assert.False(t, successor.Found)
+
+	tree.Add(ipv4FromBytes([]byte{0, 0, 0, 0}, 0), "root", nil)
+	tree.Add(ipv4FromBytes([]byte{9, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{9, 1, 0, 0}, 16), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 16), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 24), "E", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "F", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 3, 0, 0}, 16), "G", nil)
+	tree.Add(ipv4FromBytes([]byte{11, 0, 0, 0}, 8), "H", nil)
+
+	// the address is between two prefixes
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{10, 2, 3, 4}, 32))
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{10, 1, 0, 0}, 24), Tags: []GeneratedType{"E"}}, predecessor)
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{10, 3, 0, 0}, 16), Tags: []GeneratedType{"G"}}, successor)
+
+	// prefixes overlapping the address aren't neighbors
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{10, 0, 0, 0}, 8))
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{9, 1, 0, 0}, 16), Tags: []GeneratedType{"B"}}, predecessor)
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{11, 0, 0, 0}, 8), Tags: []GeneratedType{"H"}}, successor)
+
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{10, 1, 0, 0}, 16))
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{10, 0, 0, 0}, 16), Tags: []GeneratedType{"C", "D"}}, predecessor)
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{10, 3, 0, 0}, 16), Tags: []GeneratedType{"G"}}, successor)
+
+	// nothing on one side
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{200, 0, 0, 0}, 8))
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{11, 0, 0, 0}, 8), Tags: []GeneratedType{"H"}}, predecessor)
+	assert.False(t, successor.Found)
+
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{1, 2, 3, 4}, 32))
+	assert.False(t, predecessor.Found)
+	assert.Equal(t, NeighborV4{Found: true, Prefix: ipv4FromBytes([]byte{9, 0, 0, 0}, 8), Tags: []GeneratedType{"A"}}, successor)
+
+	// everything overlaps the whole address space
+	predecessor, successor = tree.FindNeighbors(ipv4FromBytes([]byte{0, 0, 0, 0}, 0))
+	assert.False(t, predecessor.Found)
+	assert.False(t, successor.Found)
+}
+
+func TestNetIPV4(t *testing.T) {
+	tree := NewTreeV4()
+
+	countIncreased, count, err := tree.AddPrefix(netip.MustParsePrefix("10.0.0.0/8"), "A", nil)
+	assert.NoError(t, err)
+	assert.True(t, countIncreased)
+	assert.Equal(t, 1, count)
+	_, _, err = tree.SetPrefix(netip.MustParsePrefix("10.1.0.0/16"), "B")
+	assert.NoError(t, err)
+	_, _, err = tree.AddPrefix(netip.MustParsePrefix("10.1.2.0/24"), "C", nil)
+	assert.NoError(t, err)
+
+	_, _, err = tree.AddPrefix(netip.MustParsePrefix("2001:db8::/32"), "D", nil)
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+	_, _, err = tree.SetPrefix(netip.MustParsePrefix("::ffff:10.0.0.0/104"), "D")
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+	_, _, err = tree.AddPrefix(netip.Prefix{}, "D", nil)
+	assert.ErrorIs(t, err, patricia.ErrInvalidAddress)
+	assert.Equal(t, 3, tree.CountTags())
+
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, tree.FindTagsAddr(netip.MustParseAddr("10.1.2.3")))
+	assert.Equal(t, []GeneratedType{"A"}, tree.FindTagsAddr(netip.MustParseAddr("10.2.2.3")))
+	assert.Empty(t, tree.FindTagsAddr(netip.MustParseAddr("::ffff:10.1.2.3")))
+	assert.Empty(t, tree.FindTagsAddr(netip.Addr{}))
+
+	found, tag := tree.FindDeepestTagAddr(netip.MustParseAddr("10.1.3.3"))
+	assert.True(t, found)
+	assert.Equal(t, "B", tag)
+	found, _ = tree.FindDeepestTagAddr(netip.MustParseAddr("11.1.3.3"))
+	assert.False(t, found)
+	found, _ = tree.FindDeepestTagAddr(netip.MustParseAddr("2001:db8::1"))
+	assert.False(t, found)
+	found, tags := tree.FindDeepestTagsAddrAppend(nil, netip.MustParseAddr("10.1.2.3"))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"C"}, tags)
+
+	prefixes := []netip.Prefix{}
+	treeIter := tree.Iterate()
+	for treeIter.Next() {
+		prefixes = append(prefixes, treeIter.Prefix())
+	}
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("10.1.2.0/24"),
+	}, prefixes)
+
+	deleted, err := tree.DeletePrefix(netip.MustParsePrefix("10.1.0.0/16"), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = tree.DeletePrefix(netip.MustParsePrefix("2001:db8::/32"), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+	assert.Equal(t, 2, tree.CountTags())
+
+	// lookups don't allocate
+	addr := netip.MustParseAddr("10.1.2.3")
+	buf := make([]GeneratedType, 0, 8)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		tree.FindDeepestTagAddr(addr)
+		tree.FindTagsAddrAppend(buf[:0], addr)
+		tree.FindDeepestTagsAddrAppend(buf[:0], addr)
+	}))
+
+	// host bits are cleared, leaving the same nodes as the masked prefix
+	masked := NewTreeV4()
+	masked.AddPrefix(netip.MustParsePrefix("10.0.0.0/8"), "E", nil)
+	unmasked := NewTreeV4()
+	_, _, err = unmasked.AddPrefix(netip.MustParsePrefix("10.1.2.3/8"), "E", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, masked.nodes, unmasked.nodes)
+	_, _, err = tree.AddPrefix(netip.MustParsePrefix("10.1.2.3/8"), "E", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []GeneratedType{"A", "E"}, tree.FindTagsAddr(netip.MustParseAddr("10.200.0.1")))
+	deleted, err = tree.DeletePrefix(netip.MustParsePrefix("10.9.9.9/8"), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestStrictV4(t *testing.T) {
+	tree := NewTreeV4()
+	matchAll := func(GeneratedType, GeneratedType) bool { return true }
+
+	countIncreased, count, err := tree.AddE(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	assert.NoError(t, err)
+	assert.True(t, countIncreased)
+	assert.Equal(t, 1, count)
+	_, _, err = tree.SetE(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B")
+	assert.NoError(t, err)
+
+	_, _, err = tree.AddE(ipv4FromBytes([]byte{10, 1, 2, 3}, 8), "C", nil)
+	assert.ErrorIs(t, err, patricia.ErrHostBitsSet)
+	_, _, err = tree.SetE(patricia.NewIPv4Address(0, 33), "C")
+	assert.ErrorIs(t, err, patricia.ErrInvalidLength)
+	deleted, err := tree.DeleteE(ipv4FromBytes([]byte{10, 1, 0, 0}, 8), matchAll, "")
+	assert.ErrorIs(t, err, patricia.ErrHostBitsSet)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 2, tree.CountTags())
+
+	deleted, err = tree.DeleteE(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), matchAll, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 1, tree.CountTags())
+
+	update := func(GeneratedType) GeneratedType { return "D" }
+	_, _, err = tree.SetOrUpdateE(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "", update)
+	assert.NoError(t, err)
+	_, _, err = tree.AddOrUpdateE(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "E", matchAll, update)
+	assert.NoError(t, err)
+	_, _, err = tree.SetOrUpdateE(ipv4FromBytes([]byte{10, 0, 0, 1}, 8), "", update)
+	assert.ErrorIs(t, err, patricia.ErrHostBitsSet)
+	_, _, err = tree.AddOrUpdateE(patricia.NewIPv4Address(0, 33), "F", matchAll, update)
+	assert.ErrorIs(t, err, patricia.ErrInvalidLength)
+	assert.Equal(t, []GeneratedType{"D", "E"}, tree.FindTags(ipv4FromBytes([]byte{10, 2, 0, 1}, 32)))
+}
+
+func TestBinaryV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 1, 0}, 24), "E", nil)
+	tree.Delete(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), func(GeneratedType, GeneratedType) bool { return true }, "")
+
+	data, err := tree.MarshalBinary()
+	assert.NoError(t, err)
+
+	loaded := NewTreeV4()
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, tree.nodes, loaded.nodes)
+	assert.Equal(t, tree.availableIndexes, loaded.availableIndexes)
+	assert.Equal(t, tree.tags, loaded.tags)
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, loaded.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+
+	// the loaded tree can still be modified
+	loaded.Add(ipv4FromBytes([]byte{10, 3, 0, 0}, 16), "F", nil)
+	assert.Equal(t, []GeneratedType{"A", "B", "F"}, loaded.FindTags(ipv4FromBytes([]byte{10, 3, 2, 1}, 32)))
+
+	// the zero value works too
+	var zero TreeV4
+	assert.NoError(t, zero.UnmarshalBinary(data))
+	assert.Equal(t, 4, zero.CountTags())
+
+	empty, err := NewTreeV4().MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, loaded.UnmarshalBinary(empty))
+	assert.Equal(t, 0, loaded.CountTags())
+
+	// corrupt data is rejected, and leaves the tree unchanged
+	corrupt := append([]byte{}, data...)
+	corrupt[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, zero.UnmarshalBinary(corrupt), patricia.ErrChecksumMismatch)
+	corrupt = append([]byte{}, data...)
+	corrupt[5]++
+	assert.ErrorIs(t, zero.UnmarshalBinary(corrupt), patricia.ErrUnsupportedVersion)
+	assert.ErrorIs(t, zero.UnmarshalBinary(data[:len(data)-1]), patricia.ErrChecksumMismatch)
+	assert.ErrorIs(t, zero.UnmarshalBinary(data[:10]), patricia.ErrInvalidBinary)
+	assert.ErrorIs(t, zero.UnmarshalBinary([]byte("not a tree at all")), patricia.ErrInvalidBinary)
+	assert.Equal(t, 4, zero.CountTags())
+
+	v6Data, err := NewTreeV6().MarshalBinary()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, zero.UnmarshalBinary(v6Data), patricia.ErrIncompatibleBinary)
+}
+
+func TestBinaryMalformedV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 0, 0}, 16), "D", nil)
+	tree.Delete(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.NotEmpty(t, tree.availableIndexes)
+	data, err := tree.MarshalBinary()
+	assert.NoError(t, err)
+
+	// mutate returns a copy of data changed by mutateFunc, with the checksum recomputed
+	mutate := func(mutateFunc func(nodes []byte, availableIndexes []byte)) []byte {
+		ret := append([]byte{}, data...)
+		nodesEnd := binaryHeaderSize + len(tree.nodes)*treeNodeV4BinarySize
+		mutateFunc(ret[binaryHeaderSize:nodesEnd], ret[nodesEnd:])
+		checksumStart := len(ret) - binaryChecksumSize
+		binary.BigEndian.PutUint32(ret[checksumStart:], crc32.ChecksumIEEE(ret[:checksumStart]))
+		return ret
+	}
+	usedIndex := tree.nodes[1].Left
+	if usedIndex == 0 {
+		usedIndex = tree.nodes[1].Right
+	}
+
+	// a node that's its own child
+	loop := mutate(func(nodes []byte, _ []byte) {
+		binary.BigEndian.PutUint32(nodes[usedIndex*treeNodeV4BinarySize:], uint32(usedIndex))
+		nodes[usedIndex*treeNodeV4BinarySize+12] = 0
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(loop), patricia.ErrInvalidBinary)
+	loop = mutate(func(nodes []byte, _ []byte) {
+		binary.BigEndian.PutUint32(nodes[usedIndex*treeNodeV4BinarySize:], 1)
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(loop), patricia.ErrInvalidBinary)
+
+	// a node below the root without a prefix
+	empty := mutate(func(nodes []byte, _ []byte) {
+		nodes[usedIndex*treeNodeV4BinarySize+12] = 0
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(empty), patricia.ErrInvalidBinary)
+
+	// an available index that's in use
+	available := mutate(func(_ []byte, availableIndexes []byte) {
+		binary.BigEndian.PutUint32(availableIndexes, uint32(usedIndex))
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(available), patricia.ErrInvalidBinary)
+
+	// an unused node with tags
+	unused := mutate(func(nodes []byte, _ []byte) {
+		binary.BigEndian.PutUint32(nodes[tree.availableIndexes[0]*treeNodeV4BinarySize+13:], 1)
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(unused), patricia.ErrInvalidBinary)
+
+	// paths longer than an address, made of nodes that each fit
+	tooLong := mutate(func(nodes []byte, _ []byte) {
+		for i := 2; i < len(tree.nodes); i++ {
+			if nodes[i*treeNodeV4BinarySize+12] != 0 {
+				nodes[i*treeNodeV4BinarySize+12] = 32
+			}
+		}
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(tooLong), patricia.ErrInvalidBinary)
+
+	// children on the wrong side of their parent
+	swapped := mutate(func(nodes []byte, _ []byte) {
+		binary.BigEndian.PutUint32(nodes[treeNodeV4BinarySize:], uint32(tree.nodes[1].Right))
+		binary.BigEndian.PutUint32(nodes[treeNodeV4BinarySize+4:], uint32(tree.nodes[1].Left))
+	})
+	assert.ErrorIs(t, tree.Clone().UnmarshalBinary(swapped), patricia.ErrInvalidBinary)
+
+	// the tag kind is in the header, so even empty trees of another tag type are rejected, unless tags can be any kind
+	empty, err = NewTreeV4().MarshalBinary()
+	assert.NoError(t, err)
+	empty[7] = tagKindBool
+	if tree.tagKind() == tagKindBool {
+		empty[7] = tagKindString
+	}
+	binary.BigEndian.PutUint32(empty[len(empty)-binaryChecksumSize:], crc32.ChecksumIEEE(empty[:len(empty)-binaryChecksumSize]))
+	if tree.tagKind() == 0 {
+		assert.NoError(t, NewTreeV4().UnmarshalBinary(empty))
+	} else {
+		assert.ErrorIs(t, NewTreeV4().UnmarshalBinary(empty), patricia.ErrIncompatibleBinary)
+	}
+
+	// lookups still work on the tree after a failed load
+	found, tag := tree.FindDeepestTag(ipv4FromBytes([]byte{10, 1, 1, 1}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "B", tag)
+
+	// trees built by adding and deleting are accepted
+	r := rand.New(rand.NewSource(1))
+	random := NewTreeV4()
+	for i := 0; i < 2000; i++ {
+		address := patricia.NewIPv4Address(r.Uint32()&0xff0f0000, uint(r.Intn(33)))
+		if r.Intn(3) == 0 {
+			random.Delete(address, func(GeneratedType, GeneratedType) bool { return true }, "")
+		} else {
+			random.Add(address, fmt.Sprintf("%d", i), nil)
+		}
+	}
+	data, err = random.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := NewTreeV4()
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, random.CountTags(), loaded.CountTags())
+}
+
+func TestFrozenV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{0, 0, 0, 0}, 0), "root", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "D", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 2, 3}, 32), "E", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 1, 0}, 24), "F", nil)
+	tree.Delete(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), func(GeneratedType, GeneratedType) bool { return true }, "")
+
+	data, err := tree.Freeze()
+	assert.NoError(t, err)
+	frozen, err := NewFrozenTreeV4(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []GeneratedType{"root", "A", "B", "C", "E"}, frozen.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+	found, tag := frozen.FindDeepestTag(ipv4FromBytes([]byte{10, 2, 3, 4}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "A", tag)
+	assert.Equal(t, []GeneratedType{"root"}, frozen.FindTags(ipv4FromBytes([]byte{0, 0, 0, 0}, 0)))
+
+	// lookups don't allocate, unless the tags are interfaces, which have to be boxed
+	if frozen.tagKind() != 0 {
+		address := ipv4FromBytes([]byte{10, 1, 2, 3}, 32)
+		buf := make([]GeneratedType, 0, 8)
+		assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+			frozen.FindDeepestTag(address)
+			frozen.FindTagsAppend(buf[:0], address)
+		}))
+	}
+
+	// lookups match the tree's
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		tree.Add(patricia.NewIPv4Address(r.Uint32(), uint(r.Intn(33))), fmt.Sprintf("%d", i), nil)
+	}
+	data, err = tree.Freeze()
+	assert.NoError(t, err)
+	frozen, err = NewFrozenTreeV4(data)
+	assert.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		address := patricia.NewIPv4Address(r.Uint32(), 32)
+		assert.Equal(t, tree.FindTags(address), frozen.FindTags(address))
+		expectedFound, expectedTag := tree.FindDeepestTag(address)
+		found, tag := frozen.FindDeepestTag(address)
+		assert.Equal(t, expectedFound, found)
+		assert.Equal(t, expectedTag, tag)
+	}
+
+	// an empty tree
+	emptyData, err := NewTreeV4().Freeze()
+	assert.NoError(t, err)
+	empty, err := NewFrozenTreeV4(emptyData)
+	assert.NoError(t, err)
+	found, _ = empty.FindDeepestTag(ipv4FromBytes([]byte{10, 1, 2, 3}, 32))
+	assert.False(t, found)
+	assert.Empty(t, empty.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+
+	// corrupt or incompatible data is rejected
+	corrupt := append([]byte{}, data...)
+	corrupt[frozenHeaderSize+treeNodeV4FrozenSize+1] ^= 1
+	_, err = NewFrozenTreeV4(corrupt)
+	assert.ErrorIs(t, err, patricia.ErrChecksumMismatch)
+	_, err = NewFrozenTreeV4(data[:frozenHeaderSize])
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	binaryData, err := tree.MarshalBinary()
+	assert.NoError(t, err)
+	_, err = NewFrozenTreeV4(binaryData)
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	v6Data, err := NewTreeV6().Freeze()
+	assert.NoError(t, err)
+	_, err = NewFrozenTreeV4(v6Data)
+	assert.ErrorIs(t, err, patricia.ErrIncompatibleBinary)
+}
+
+func TestFrozenMalformedV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "a", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "b", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "c", nil)
+	data, err := tree.Freeze()
+	assert.NoError(t, err)
+
+	// mutate returns a copy of data with node 2 changed by mutateFunc, and the checksum recomputed
+	mutate := func(mutateFunc func(node []byte)) []byte {
+		ret := append([]byte{}, data...)
+		mutateFunc(ret[frozenHeaderSize+2*treeNodeV4FrozenSize:])
+		checksumStart := len(ret) - binaryChecksumSize
+		binary.BigEndian.PutUint32(ret[checksumStart:], crc32.ChecksumIEEE(ret[:checksumStart]))
+		return ret
+	}
+	frozen, err := NewFrozenTreeV4(mutate(func([]byte) {}))
+	assert.NoError(t, err)
+	assert.Equal(t, []GeneratedType{"a"}, frozen.FindTags(ipv4FromBytes([]byte{10, 2, 2, 2}, 32)))
+
+	// more tags than the node has
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint32(node[treeNodeV4BinarySize-4:], 3)
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint32(node[treeNodeV4BinarySize-4:], 0xffffffff)
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+
+	// fewer tags than the node has, leaving tags no node has
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint32(node[treeNodeV4BinarySize-4:], 0)
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+
+	// tags that don't start on a tag
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint64(node[treeNodeV4BinarySize:], 1)
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint64(node[treeNodeV4BinarySize:], 1<<62)
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+
+	// a path longer than an address, made of nodes that each fit
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		node[12] = 32
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+
+	// a node with two parents
+	_, err = NewFrozenTreeV4(mutate(func(node []byte) {
+		binary.BigEndian.PutUint32(node[4:], binary.BigEndian.Uint32(node))
+	}))
+	assert.ErrorIs(t, err, patricia.ErrInvalidBinary)
+
+	// the tag kind is in the header, so even empty trees of another tag type are rejected, unless tags can be any kind
+	empty, err := NewTreeV4().Freeze()
+	assert.NoError(t, err)
+	empty[7] = tagKindBool
+	if frozen.tagKind() == tagKindBool {
+		empty[7] = tagKindString
+	}
+	binary.BigEndian.PutUint32(empty[len(empty)-binaryChecksumSize:], crc32.ChecksumIEEE(empty[:len(empty)-binaryChecksumSize]))
+	_, err = NewFrozenTreeV4(empty)
+	if frozen.tagKind() == 0 {
+		assert.NoError(t, err)
+	} else {
+		assert.ErrorIs(t, err, patricia.ErrIncompatibleBinary)
+	}
+}
+
+func TestFrozenFileV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	data, err := tree.Freeze()
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "tree")
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	mapped, err := patricia.MapFile(path)
+	assert.NoError(t, err)
+	defer patricia.UnmapFile(mapped)
+
+	frozen, err := NewFrozenTreeV4(mapped)
+	assert.NoError(t, err)
+	found, tag := frozen.FindDeepestTag(ipv4FromBytes([]byte{10, 1, 2, 3}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "A", tag)
+}
+
+func TestTSVV4(t *testing.T) {
+	tree := NewTreeV4()
+	count, err := tree.LoadTSV(strings.NewReader("# prefixes\n10.0.0.0/8\tA\n\n10.0.0.0/8\tB\n192.168.1.7\twith\ttab\r\n10.1.0.0/16\tC\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, tree.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+
+	var buf strings.Builder
+	assert.NoError(t, tree.WriteTSV(&buf))
+	assert.Equal(t, "10.0.0.0/8\tA\n10.0.0.0/8\tB\n10.1.0.0/16\tC\n192.168.1.7/32\twith\ttab\n", buf.String())
+
+	loaded := NewTreeV4()
+	count, err = loaded.LoadTSV(strings.NewReader(buf.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, tree.CountTags(), loaded.CountTags())
+
+	// errors have the line number, and the lines before it are loaded
+	loaded = NewTreeV4()
+	count, err = loaded.LoadTSV(strings.NewReader("10.0.0.0/8\tA\n# comment\n2001:db8::/32\tB\n"))
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+	assert.Contains(t, err.Error(), "line 3")
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, loaded.CountTags())
+	_, err = loaded.LoadTSV(strings.NewReader("10.0.0.0/8\tA\n10.0.0.0/8\n"))
+	assert.ErrorIs(t, err, patricia.ErrMalformedLine)
+	assert.Contains(t, err.Error(), "line 2")
+	_, err = loaded.LoadTSV(strings.NewReader("10.0.0.0/33\tA\n"))
+	assert.ErrorIs(t, err, patricia.ErrPrefixLengthOutOfRange)
+	assert.Contains(t, err.Error(), "line 1")
+
+	tree.Add(ipv4FromBytes([]byte{10, 2, 0, 0}, 16), "line\nbreak", nil)
+	assert.ErrorIs(t, tree.WriteTSV(&buf), patricia.ErrMalformedLine)
+}
+
+func TestTSVFileV4(t *testing.T) {
+	file, err := os.Open("../test_tags.tsv")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	tree := NewTreeV4()
+	count, err := tree.LoadTSV(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 100013, count)
+	assert.Equal(t, 100013, tree.CountTags())
+
+	found, tag := tree.FindDeepestTag(ipv4FromBytes([]byte{184, 1, 2, 3}, 32))
+	assert.True(t, found)
+	assert.Equal(t, "22222", tag)
+}
+
+func TestJSONV4(t *testing.T) {
+	tree := NewTreeV4()
+	tree.Add(ipv4FromBytes([]byte{10, 1, 0, 0}, 16), "C", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "A", nil)
+	tree.Add(ipv4FromBytes([]byte{10, 0, 0, 0}, 8), "B", nil)
+	tree.Add(ipv4FromBytes([]byte{192, 168, 1, 7}, 32), "D", nil)
+
+	data, err := json.Marshal(tree)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"10.0.0.0/8":["A","B"],"10.1.0.0/16":["C"],"192.168.1.7/32":["D"]}`, string(data))
+
+	loaded := NewTreeV4()
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	assert.Equal(t, 4, loaded.CountTags())
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, loaded.FindTags(ipv4FromBytes([]byte{10, 1, 2, 3}, 32)))
+	reencoded, err := json.Marshal(loaded)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(reencoded))
+
+	// as a field of another struct
+	var config struct {
+		Tree *TreeV4 `json:"tree"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"tree":{"10.0.0.0/8":["A"]}}`), &config))
+	assert.Equal(t, 1, config.Tree.CountTags())
+
+	empty, err := json.Marshal(NewTreeV4())
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(empty))
+
+	// errors leave the tree unchanged
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"10.0.0.0/8":["A"],"2001:db8::/32":["B"]}`), loaded), patricia.ErrAddressFamily)
+	assert.Error(t, json.Unmarshal([]byte(`["10.0.0.0/8"]`), loaded))
+	assert.Error(t, json.Unmarshal([]byte(`{"10.0.0.0/8":["A"],"10.1.0.0/8":["B"]}`), loaded))
+	assert.Error(t, json.Unmarshal([]byte(`{"10.0.0.0/8":["A"],"10.0.0.0/8 ":["B"]}`), loaded))
+	assert.Equal(t, 4, loaded.CountTags())
+
+	// null leaves the tree unchanged
+	assert.NoError(t, json.Unmarshal([]byte(`null`), loaded))
+	assert.Equal(t, 4, loaded.CountTags())
+	var valueConfig struct {
+		Tree TreeV4 `json:"tree"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"tree":{"10.0.0.0/8":["A"]}}`), &valueConfig))
+	assert.NoError(t, json.Unmarshal([]byte(`{"tree":null}`), &valueConfig))
+	assert.Equal(t, 1, valueConfig.Tree.CountTags())
+}
@@ -2,8 +2,11 @@ package template
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/kentik/patricia"
@@ -473,3 +476,403 @@ func TestIterateV6(t *testing.T) {
 	}
 	assert.Equal(t, expected, got)
 }
+
+// test traversal of the part of the tree covered by an address
+func TestIterateSubtreeV6(t *testing.T) {
+	tree := NewTreeV6()
+
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::1/128", 128), "C", nil)
+	tree.Add(ipv6FromString("2001:db9::/128", 32), "D", nil)
+
+	got := []string{}
+	iter := tree.IterateSubtree(ipv6FromString("2001:db8:1::/128", 48))
+	for iter.Next() {
+		got = append(got, iter.Address().String())
+	}
+	assert.Equal(t, []string{"2001:db8:1::/48", "2001:db8:1::1/128"}, got)
+
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, tree.FindSubtreeTags(ipv6FromString("2001:db8::/128", 32)))
+	assert.Equal(t, []GeneratedType{"A", "B", "C", "D"}, tree.FindSubtreeTags(ipv6FromString("2001:db8::/128", 16)))
+	assert.Equal(t, []GeneratedType{}, tree.FindSubtreeTags(ipv6FromString("2001:db8:2::/128", 48)))
+}
+
+func TestFindDeepestWithPrefixV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::/128", 64), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::ff00/128", 120), "C", nil)
+
+	found, prefix, tag := tree.FindDeepestTagWithPrefix(ipv6FromString("2001:db8:1:2::ff12/128", 128))
+	assert.True(t, found)
+	assert.Equal(t, "C", tag)
+	assert.Equal(t, "2001:db8:1:2::ff00/120", prefix.String())
+
+	found, prefix, tags := tree.FindDeepestTagsWithPrefix(ipv6FromString("2001:db8:1:2::1/128", 128))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"B"}, tags)
+	assert.Equal(t, "2001:db8:1:2::/64", prefix.String())
+
+	found, prefix, tag = tree.FindDeepestTagWithPrefix(ipv6FromString("2001:db8:ffff::1/128", 128))
+	assert.True(t, found)
+	assert.Equal(t, "A", tag)
+	assert.Equal(t, "2001:db8::/32", prefix.String())
+
+	found, _, _ = tree.FindDeepestTagWithPrefix(ipv6FromString("2001:db9::1/128", 128))
+	assert.False(t, found)
+}
+
+func TestFindMatchesV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::/128", 64), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::ff00/128", 120), "C", nil)
+
+	assert.Equal(t, []MatchV6{
+		{Prefix: ipv6FromString("2001:db8::/128", 32), Tag: "A"},
+		{Prefix: ipv6FromString("2001:db8:1:2::/128", 64), Tag: "B"},
+	}, tree.FindMatches(ipv6FromString("2001:db8:1:2::1/128", 128)))
+}
+
+func TestGetExactV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::/128", 64), "B", nil)
+
+	found, tags := tree.GetExact(ipv6FromString("2001:db8:1:2::/128", 64))
+	assert.True(t, found)
+	assert.Equal(t, []GeneratedType{"B"}, tags)
+
+	found, tags = tree.GetExact(ipv6FromString("2001:db8:1:3::/128", 64))
+	assert.False(t, found)
+	assert.Equal(t, 0, len(tags))
+}
+
+// test starting the traversal somewhere in the middle of the tree
+func TestIterateFromV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::1/128", 128), "C", nil)
+	tree.Add(ipv6FromString("2001:db9::/128", 32), "D", nil)
+
+	collect := func(iter *TreeIteratorV6) []string {
+		got := []string{}
+		for iter.Next() {
+			got = append(got, iter.Address().String())
+		}
+		return got
+	}
+
+	assert.Equal(t, []string{"2001:db8:1::/48", "2001:db8:1::1/128", "2001:db9::/32"},
+		collect(tree.IterateFrom(ipv6FromString("2001:db8:1::/128", 40))))
+	assert.Equal(t, []string{"2001:db8:1::1/128", "2001:db9::/32"},
+		collect(tree.IterateFrom(ipv6FromString("2001:db8:1::/128", 128))))
+	assert.Equal(t, []string{"2001:db8::/32", "2001:db8:1::/48", "2001:db8:1::1/128"},
+		collect(tree.IterateRange(ipv6FromString("2001:db8::/128", 16), ipv6FromString("2001:db9::/128", 32))))
+}
+
+// test reverse tree traversal
+func TestIterateReverseV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::1/128", 128), "C", nil)
+	tree.Add(ipv6FromString("2001:db9::/128", 32), "D", nil)
+
+	got := []string{}
+	iter := tree.IterateReverse()
+	for iter.Next() {
+		got = append(got, iter.Address().String())
+	}
+	assert.Equal(t, []string{"2001:db9::/32", "2001:db8:1::1/128", "2001:db8:1::/48", "2001:db8::/32"}, got)
+}
+
+// test range-over-func traversal
+func TestRangeV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "C", nil)
+
+	got := map[string][]GeneratedType{}
+	for address, tags := range tree.All() {
+		got[address.String()] = tags
+	}
+	assert.Equal(t, map[string][]GeneratedType{
+		"2001:db8::/32":   {"A"},
+		"2001:db8:1::/48": {"B", "C"},
+	}, got)
+
+	prefixes := []string{}
+	for address := range tree.Prefixes() {
+		prefixes = append(prefixes, address.String())
+	}
+	assert.Equal(t, []string{"2001:db8::/32", "2001:db8:1::/48"}, prefixes)
+
+	tags := []GeneratedType{}
+	for tag := range tree.Tags() {
+		tags = append(tags, tag)
+	}
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, tags)
+}
+
+func TestSetOperationsV6(t *testing.T) {
+	left := NewTreeV6()
+	left.Add(ipv6FromString("2001:db8::/128", 32), "L1", nil)
+	left.Add(ipv6FromString("2001:db8:1::/128", 48), "L2", nil)
+
+	right := NewTreeV6()
+	right.Add(ipv6FromString("2001:db8::/128", 32), "R1", nil)
+	right.Add(ipv6FromString("2001:db8:2::/128", 48), "R2", nil)
+
+	prefixes := func(tree *TreeV6) []string {
+		ret := []string{}
+		for address := range tree.Prefixes() {
+			ret = append(ret, address.String())
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"2001:db8::/32", "2001:db8:1::/48", "2001:db8:2::/48"}, prefixes(left.Union(right, nil)))
+	assert.Equal(t, []string{"2001:db8::/32"}, prefixes(left.Intersect(right, nil)))
+	assert.Equal(t, []string{"2001:db8:1::/48"}, prefixes(left.Difference(right, nil)))
+}
+
+func TestDiffV6(t *testing.T) {
+	oldTree := NewTreeV6()
+	oldTree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	oldTree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+
+	newTree := NewTreeV6()
+	newTree.Add(ipv6FromString("2001:db8::/128", 32), "A2", nil)
+	newTree.Add(ipv6FromString("2001:db8:2::/128", 48), "C", nil)
+
+	diffs := []DiffV6{}
+	for diff := range oldTree.Diff(newTree, func(a GeneratedType, b GeneratedType) bool { return a == b }) {
+		diffs = append(diffs, diff)
+	}
+	assert.Equal(t, []DiffV6{
+		{Type: DiffChanged, Address: ipv6FromString("2001:db8::/128", 32), OldTags: []GeneratedType{"A"}, NewTags: []GeneratedType{"A2"}},
+		{Type: DiffRemoved, Address: ipv6FromString("2001:db8:1::/128", 48), OldTags: []GeneratedType{"B"}},
+		{Type: DiffAdded, Address: ipv6FromString("2001:db8:2::/128", 48), NewTags: []GeneratedType{"C"}},
+	}, diffs)
+}
+
+func TestAggregateV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 64), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:0:1::/128", 64), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:0:1::1/128", 128), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:0:1::2/128", 128), "B", nil)
+
+	assert.Equal(t, 2, tree.Aggregate(func(a GeneratedType, b GeneratedType) bool { return a == b }))
+
+	prefixes := []string{}
+	for address := range tree.Prefixes() {
+		prefixes = append(prefixes, address.String())
+	}
+	assert.Equal(t, []string{"2001:db8::/63", "2001:db8:0:1::2/128"}, prefixes)
+}
+
+func TestAddRangeV6(t *testing.T) {
+	tree := NewTreeV6()
+	assert.Equal(t, 3, tree.AddRange(ipv6FromString("2001:db8:0:1:ffff:ffff:ffff:ffff/128", 128), ipv6FromString("2001:db8:0:3::1/128", 128), "A", nil))
+	assert.Equal(t, 1, tree.SetRange(ipv6FromString("2001:db8:0:2::/128", 128), ipv6FromString("2001:db8:0:2:ffff:ffff:ffff:ffff/128", 128), "B"))
+
+	prefixes := []string{}
+	for address := range tree.Prefixes() {
+		prefixes = append(prefixes, address.String())
+	}
+	assert.Equal(t, []string{"2001:db8:0:1:ffff:ffff:ffff:ffff/128", "2001:db8:0:2::/64", "2001:db8:0:3::/127"}, prefixes)
+
+	found, tag := tree.FindDeepestTag(ipv6FromString("2001:db8:0:2::5/128", 128))
+	assert.True(t, found)
+	assert.Equal(t, "B", tag)
+}
+
+func TestFindOverlappingV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/128", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1:2::/128", 64), "C", nil)
+	tree.Add(ipv6FromString("2001:db8:2::/128", 48), "D", nil)
+
+	assert.Equal(t, []OverlapV6{
+		{Prefix: ipv6FromString("2001:db8::/128", 32), Tags: []GeneratedType{"A"}, Type: OverlapCovers},
+		{Prefix: ipv6FromString("2001:db8:1::/128", 48), Tags: []GeneratedType{"B"}, Type: OverlapCovers},
+		{Prefix: ipv6FromString("2001:db8:1:2::/128", 64), Tags: []GeneratedType{"C"}, Type: OverlapCovered},
+	}, tree.FindOverlapping(ipv6FromString("2001:db8:1::/128", 56)))
+}
+
+func TestAllocateV6(t *testing.T) {
+	pool := ipv6FromString("2001:db8::/128", 48)
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 56), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:0:100::/128", 64), "A", nil)
+
+	ok, prefix := tree.Allocate(pool, 56, "B")
+	assert.True(t, ok)
+	assert.Equal(t, ipv6FromString("2001:db8:0:200::/128", 56), prefix)
+
+	ok, prefix = tree.AllocateBestFit(pool, 64, "B")
+	assert.True(t, ok)
+	assert.Equal(t, ipv6FromString("2001:db8:0:101::/128", 64), prefix)
+
+	assert.Equal(t, 1, tree.Release(ipv6FromString("2001:db8::/128", 56)))
+	ok, prefix = tree.Allocate(pool, 127, "C")
+	assert.True(t, ok)
+	assert.Equal(t, ipv6FromString("2001:db8::/128", 127), prefix)
+}
+
+func TestGapsV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 33), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:c000::/128", 34), "B", nil)
+
+	assert.Equal(t, []patricia.IPv6Address{
+		ipv6FromString("2001:db8:8000::/128", 34),
+	}, tree.Gaps(ipv6FromString("2001:db8::/128", 32)))
+}
+
+func TestFindNeighborsV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/128", 48), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:0:ffff::/128", 64), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:2::/128", 48), "C", nil)
+
+	predecessor, successor := tree.FindNeighbors(ipv6FromString("2001:db8:1::1/128", 128))
+	assert.Equal(t, NeighborV6{Found: true, Prefix: ipv6FromString("2001:db8:0:ffff::/128", 64), Tags: []GeneratedType{"B"}}, predecessor)
+	assert.Equal(t, NeighborV6{Found: true, Prefix: ipv6FromString("2001:db8:2::/128", 48), Tags: []GeneratedType{"C"}}, successor)
+}
+
+func TestNetIPV6(t *testing.T) {
+	tree := NewTreeV6()
+	_, _, err := tree.AddPrefix(netip.MustParsePrefix("2001:db8::/32"), "A", nil)
+	assert.NoError(t, err)
+	_, _, err = tree.SetPrefix(netip.MustParsePrefix("::ffff:10.0.0.0/104"), "B")
+	assert.NoError(t, err)
+	_, _, err = tree.AddPrefix(netip.MustParsePrefix("10.0.0.0/8"), "C", nil)
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+
+	assert.Equal(t, []GeneratedType{"A"}, tree.FindTagsAddr(netip.MustParseAddr("2001:db8::1")))
+	found, tag := tree.FindDeepestTagAddr(netip.MustParseAddr("::ffff:10.1.2.3"))
+	assert.True(t, found)
+	assert.Equal(t, "B", tag)
+	found, _ = tree.FindDeepestTagAddr(netip.MustParseAddr("10.1.2.3"))
+	assert.False(t, found)
+
+	treeIter := tree.Iterate()
+	assert.True(t, treeIter.Next())
+	assert.Equal(t, netip.MustParsePrefix("::ffff:10.0.0.0/104"), treeIter.Prefix())
+	assert.True(t, treeIter.Next())
+	assert.Equal(t, netip.MustParsePrefix("2001:db8::/32"), treeIter.Prefix())
+
+	deleted, err := tree.DeletePrefix(netip.MustParsePrefix("2001:db8::/32"), func(GeneratedType, GeneratedType) bool { return true }, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	addr := netip.MustParseAddr("::ffff:10.1.2.3")
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		tree.FindDeepestTagAddr(addr)
+	}))
+
+	// host bits are cleared, leaving the same nodes as the masked prefix
+	masked := NewTreeV6()
+	masked.AddPrefix(netip.MustParsePrefix("2001:db8::/32"), "C", nil)
+	unmasked := NewTreeV6()
+	_, _, err = unmasked.AddPrefix(netip.MustParsePrefix("2001:db8::1/32"), "C", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, masked.nodes, unmasked.nodes)
+}
+
+func TestStrictV6(t *testing.T) {
+	tree := NewTreeV6()
+	matchAll := func(GeneratedType, GeneratedType) bool { return true }
+
+	_, _, err := tree.AddE(ipv6FromString("2001:db8::/32", 32), "A", nil)
+	assert.NoError(t, err)
+	_, _, err = tree.SetE(ipv6FromString("2001:db8::1/64", 64), "B")
+	assert.ErrorIs(t, err, patricia.ErrHostBitsSet)
+	_, _, err = tree.AddE(patricia.IPv6Address{Length: 129}, "B", nil)
+	assert.ErrorIs(t, err, patricia.ErrInvalidLength)
+	assert.Equal(t, 1, tree.CountTags())
+
+	deleted, err := tree.DeleteE(ipv6FromString("2001:db8::/32", 32), matchAll, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestBinaryV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/32", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/48", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::1/128", 128), "C", nil)
+
+	data, err := tree.MarshalBinary()
+	assert.NoError(t, err)
+
+	loaded := NewTreeV6()
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, tree.nodes, loaded.nodes)
+	assert.Equal(t, tree.tags, loaded.tags)
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, loaded.FindTags(ipv6FromString("2001:db8:1::1/128", 128)))
+
+	v4Data, err := NewTreeV4().MarshalBinary()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, loaded.UnmarshalBinary(v4Data), patricia.ErrIncompatibleBinary)
+}
+
+func TestFrozenV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/32", 32), "A", nil)
+	tree.Add(ipv6FromString("2001:db8:1::/48", 48), "B", nil)
+	tree.Add(ipv6FromString("2001:db8:1::1/128", 128), "C", nil)
+	tree.Add(ipv6FromString("2001:db8:2::/48", 48), "D", nil)
+
+	data, err := tree.Freeze()
+	assert.NoError(t, err)
+	frozen, err := NewFrozenTreeV6(data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []GeneratedType{"A", "B", "C"}, frozen.FindTags(ipv6FromString("2001:db8:1::1/128", 128)))
+	found, tag := frozen.FindDeepestTag(ipv6FromString("2001:db8:2::1/128", 128))
+	assert.True(t, found)
+	assert.Equal(t, "D", tag)
+	found, _ = frozen.FindDeepestTag(ipv6FromString("2001:db9::1/128", 128))
+	assert.False(t, found)
+
+	v4Data, err := NewTreeV4().Freeze()
+	assert.NoError(t, err)
+	_, err = NewFrozenTreeV6(v4Data)
+	assert.ErrorIs(t, err, patricia.ErrIncompatibleBinary)
+}
+
+func TestTSVV6(t *testing.T) {
+	tree := NewTreeV6()
+	count, err := tree.LoadTSV(strings.NewReader("2001:db8::/32\tA\n::ffff:10.0.0.0/104\tB\n2001:db8::1\tC\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	var buf strings.Builder
+	assert.NoError(t, tree.WriteTSV(&buf))
+	assert.Equal(t, "::ffff:10.0.0.0/104\tB\n2001:db8::/32\tA\n2001:db8::1/128\tC\n", buf.String())
+
+	_, err = tree.LoadTSV(strings.NewReader("10.0.0.0/8\tD\n"))
+	assert.ErrorIs(t, err, patricia.ErrAddressFamily)
+}
+
+func TestJSONV6(t *testing.T) {
+	tree := NewTreeV6()
+	tree.Add(ipv6FromString("2001:db8::/32", 32), "A", nil)
+	tree.Add(ipv6FromString("::ffff:10.0.0.0/104", 104), "B", nil)
+
+	data, err := json.Marshal(tree)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"::ffff:10.0.0.0/104":["B"],"2001:db8::/32":["A"]}`, string(data))
+
+	loaded := NewTreeV6()
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	assert.Equal(t, []GeneratedType{"B"}, loaded.FindTags(ipv6FromString("::ffff:10.1.2.3/128", 128)))
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"10.0.0.0/8":["A"]}`), loaded), patricia.ErrAddressFamily)
+}
@@ -0,0 +1,9 @@
+package patricia
+
+import (
+	"errors"
+)
+
+// ErrMalformedLine is returned when a TSV line isn't a prefix and a tag separated by a tab, or a tag can't be written
+// as one
+var ErrMalformedLine = errors.New("malformed TSV line")